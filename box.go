@@ -52,6 +52,16 @@ func (db *drawBox) draw(s tcell.Screen, style tcell.Style) *drawBox {
 	return db
 }
 
+// clear fills the box including the border with empty cells.
+func (db *drawBox) clear(s tcell.Screen, style tcell.Style) *drawBox {
+	for y := db.Min.Y; y <= db.Max.Y; y++ {
+		for x := db.Min.X; x <= db.Max.X; x++ {
+			s.SetContent(x, y, ' ', nil, style)
+		}
+	}
+	return db
+}
+
 func (db *drawBox) getPoint(x, y int) image.Point {
 	return image.Pt(x+db.Min.X+db.borderSize, y+db.Min.Y+db.borderSize)
 }
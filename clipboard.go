@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"sync"
+)
+
+// osc52 returns the escape sequence which asks the terminal to put the data
+// on the system clipboard.
+func osc52(data []byte) string {
+	return "\x1b]52;c;" + base64.StdEncoding.EncodeToString(data) + "\a"
+}
+
+// copyToClipboard copies the selection, or the whole image when nothing is
+// selected, as a PNG to the system clipboard.
+func (c *CmdPxl) copyToClipboard() {
+	r := c.selectionBounds()
+	if err := c.writeClipboard(r); err != nil {
+		c.message = "clipboard: " + err.Error()
+		return
+	}
+	c.message = fmt.Sprintf("copied %dx%d pixels to the clipboard", r.Dx(), r.Dy())
+}
+
+func (c *CmdPxl) writeClipboard(r image.Rectangle) error {
+	if c.terminal == nil {
+		return errors.New("not supported by the terminal")
+	}
+	var buf bytes.Buffer
+	if err := encodePNG(&buf, c.m.flatten().SubImage(r), defaultFormatOptions); err != nil {
+		return err
+	}
+	// the screen is locked while drawing, holding the lock keeps the
+	// sequence from being interleaved with a redraw
+	if l, ok := c.s.(sync.Locker); ok {
+		l.Lock()
+		defer l.Unlock()
+	}
+	_, err := io.WriteString(c.terminal, osc52(buf.Bytes()))
+	return err
+}
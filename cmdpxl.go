@@ -4,9 +4,13 @@ import (
 	"fmt"
 	"image"
 	"image/color"
+	"io"
+	"math"
 	"strings"
+	"time"
 
 	"github.com/gdamore/tcell/v2"
+	"github.com/lucasb-eyer/go-colorful"
 )
 
 type direction bool
@@ -14,20 +18,27 @@ type state int
 type saveImageCallback = func(fileName string, m image.Image) error
 
 const (
-	maxHue     = 380
-	borderSize = 1
+	maxHue        = 380
+	borderSize    = 1
+	toleranceStep = 0.05
+	sectionWidth  = 12
 
 	dirIncrease  direction = true
 	dirDecrease  direction = false
 	stateDrawing state     = iota
 	stateQuit
+	stateFilters
+	stateLayers
+	stateFloating
+	stateTransform
+	stateScale
+	statePalette
+	stateFrames
+	statePlayback
+
+	tickInterval = 250 * time.Millisecond
 )
 
-type historyItem struct {
-	point image.Point
-	color color.Color
-}
-
 type CmdPxl struct {
 	currentState state
 
@@ -42,68 +53,86 @@ type CmdPxl struct {
 
 	imageBox *drawBox
 
-	cursorX int
-	cursorY int
+	// cursor and pan are in image coordinates
+	cursor image.Point
+	pan    image.Point
 
 	paddingX int
 	paddingY int
 
-	panX int
-	panY int
-
 	paletteSize    int
-	m              layeredImage
+	m              *layeredImage
 	fileName       string
 	interfaceStyle tcell.Style
 	s              tcell.Screen
+	terminal       io.Writer
+	message        string
 	penColor       cmdColor
-	history        []historyItem
+	fill           fillOptions
+	zoom           int
+	halfBlock      bool
+	mouse          mouseState
+	history        *history
+	stroke         *pixelCommand
+	tool           tool
+	shape          *shape
+	selection      image.Rectangle
+	marking        bool
+	markAnchor     image.Point
+	clipboard      *clip
+	floating       *floating
+	antsPhase      int
+	antsScheduled  bool
+	filters        []Filter
+	filterMenu     *menu
+	transformMenu  *menu
+	resizeMenu     *menu
+	resize         *canvasSize
+	scaleMenu      *menu
+	paletteIndex   int
+	onionSkin      bool
+	playback       int // counts started playbacks to ignore stale ticks
+	preview        layer
 
 	saveImage saveImageCallback
 }
 
-func NewCmdPxl(fileName string, m image.Image, saveImage saveImageCallback) *CmdPxl {
+// NewCmdPxl creates the editor for the image. The screen is optional, a
+// terminal screen is used when it is nil.
+func NewCmdPxl(fileName string, m image.Image, saveImage saveImageCallback, s tcell.Screen) *CmdPxl {
 	b := m.Bounds()
 	paletteSize := 11
+	li, ok := m.(*layeredImage)
+	if !ok {
+		li = newLayeredImage(m)
+	}
 
-	return &CmdPxl{
+	c := &CmdPxl{
 		currentState:   stateDrawing,
 		interfaceStyle: tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorReset),
 		fileName:       fileName,
-		m:              layeredImage{make(layer), m},
+		m:              li,
 		imageWidth:     b.Max.X,
 		imageHeight:    b.Max.Y,
-		panX:           0,
-		panY:           0,
 		maxDrawWidth:   0,
 		maxDrawHeight:  0,
 		paddingY:       1,
-		cursorX:        0,
-		cursorY:        0,
+		zoom:           defaultZoom,
 		paletteSize:    paletteSize,
 		penColor:       *NewCmdColor(color.White, paletteSize),
-		history:        make([]historyItem, 0),
+		history:        newHistory(),
 		saveImage:      saveImage,
+		s:              s,
 	}
+	return c
 }
 
 func (c *CmdPxl) Run() error {
-	var err error
-
-	// Initialize screen
-	c.s, err = tcell.NewScreen()
-	if err != nil {
-		return err
-	}
-	if err := c.s.Init(); err != nil {
+	if err := c.init(); err != nil {
 		return err
 	}
-
-	c.s.SetStyle(c.interfaceStyle)
-
 	defer c.s.Fini()
 
-mainLoop:
 	for {
 		// Update screen
 		c.s.Show()
@@ -112,139 +141,454 @@ mainLoop:
 		ev := c.s.PollEvent()
 
 		// Process event
-		switch ev := ev.(type) {
-		case *tcell.EventResize:
-			c.screenWidth, c.screenHeight = ev.Size()
-			c.paddingX = max(0, (c.screenWidth-max(48, c.imageWidth*2))/2)
-
-			c.maxDrawWidth = c.screenWidth - 2*borderSize
-			c.maxDrawHeight = c.screenHeight - 13 // chrome
-
-			c.imageBox = c.getImageBox()
-			c.s.Sync()
-		case *tcell.EventKey:
-			if c.currentState == stateDrawing {
-				// quit
-				if ev.Key() == tcell.KeyEscape || ev.Key() == tcell.KeyCtrlC || ev.Rune() == 'x' {
-					// any changes made
-					if len(c.history) > 0 {
-						c.currentState = stateQuit
-					} else {
-						// quit directly
-						break mainLoop
-					}
-				}
-				// move cursor
-				if ev.Rune() == 'w' {
-					c.cursorY = mod(c.cursorY-1, c.imageHeight)
-				}
-				if ev.Rune() == 's' {
-					c.cursorY = mod(c.cursorY+1, c.imageHeight)
-				}
-				if ev.Rune() == 'a' {
-					c.cursorX = mod(c.cursorX-1, c.imageWidth)
-				}
-				if ev.Rune() == 'd' {
-					c.cursorX = mod(c.cursorX+1, c.imageWidth)
-				}
-				if ev.Rune() == 'e' || ev.Rune() == ' ' {
-					pt := image.Pt(c.cursorX+c.panX, c.cursorY+c.panY)
-					c.history = append(c.history, historyItem{pt, c.penColor.c})
-					c.m.Set(pt, c.penColor.c)
-				}
-				if ev.Rune() == 'z' {
-					l := len(c.history)
-					if l > 0 {
-						c.history = c.history[:l-1]
-						c.m.l = getLayerFromHistory(c.history)
-					}
-				}
-				if ev.Rune() == 'D' {
-					// debug
-					newDrawBox(0, 0, 5, 3).draw(c.s, c.interfaceStyle)
-					drawText(c.s, 0, 10, c.interfaceStyle, "box: "+c.imageBox.String())
-					drawText(c.s, 0, 11, c.interfaceStyle, "canvas: "+c.imageBox.getCanvas().String())
-					drawText(c.s, 0, 12, c.interfaceStyle, fmt.Sprintf("WxH: %dx%d", c.imageBox.getCanvas().Dx(), c.imageBox.getCanvas().Dy()))
-					drawText(c.s, 0, 13, c.interfaceStyle, fmt.Sprintf("Pan XxY: %dx%d", c.panX, c.panY))
-					drawText(c.s, 0, 14, c.interfaceStyle, fmt.Sprintf("image XxY: %dx%d", c.imageWidth, c.imageHeight))
-				}
+		quit, err := c.handleEvent(ev)
+		if err != nil || quit {
+			return err
+		}
+		c.draw()
+	}
+}
 
-				// colors
+// init initializes the screen. A terminal screen is created unless a screen
+// was passed to NewCmdPxl.
+func (c *CmdPxl) init() error {
+	if c.s == nil {
+		s, terminal, err := newTerminalScreen()
+		if err != nil {
+			return err
+		}
+		c.s = s
+		c.terminal = terminal
+	}
+	if err := c.s.Init(); err != nil {
+		return err
+	}
 
-				// hue
-				if ev.Rune() == 'j' {
-					c.penColor.changeHue(dirIncrease)
-				}
-				if ev.Rune() == 'u' {
-					c.penColor.changeHue(dirDecrease)
-				}
+	c.s.SetStyle(c.interfaceStyle)
+	c.s.EnableMouse()
+	return nil
+}
 
-				// saturation
-				if ev.Rune() == 'k' {
-					c.penColor.changeSaturation(dirIncrease)
+// handleEvent processes a single event and reports if the editor should quit.
+func (c *CmdPxl) handleEvent(ev tcell.Event) (bool, error) {
+	switch ev := ev.(type) {
+	case *tcell.EventResize:
+		c.screenWidth, c.screenHeight = ev.Size()
+		c.layout()
+		c.s.Sync()
+	case *tcell.EventInterrupt:
+		switch tick := ev.Data().(type) {
+		case playbackTick:
+			c.nextFrame(tick)
+		case antsTick:
+			c.antsScheduled = false
+			if !c.selection.Empty() {
+				c.antsPhase++
+			}
+		}
+	case *tcell.EventMouse:
+		if c.currentState == stateDrawing {
+			c.handleMouse(ev)
+		}
+	case *tcell.EventKey:
+		c.message = ""
+		if c.currentState == stateDrawing {
+			// quit
+			if ev.Key() == tcell.KeyEscape && c.shape != nil {
+				c.cancelShape()
+			} else if ev.Key() == tcell.KeyEscape && !c.selection.Empty() {
+				c.selectNone()
+			} else if ev.Key() == tcell.KeyEscape || ev.Key() == tcell.KeyCtrlC || ev.Rune() == 'x' {
+				c.penUp()
+				// any changes made
+				if c.history.changed() {
+					c.currentState = stateQuit
+				} else {
+					// quit directly
+					return true, nil
 				}
-				if ev.Rune() == 'i' {
-					c.penColor.changeSaturation(dirDecrease)
+			}
+			// continuous draw
+			if ev.Rune() == 'p' {
+				if c.stroke != nil {
+					c.penUp()
+				} else {
+					c.stroke = newPixelCommand()
+					c.stroke.set(c.m, c.cursor, c.penColor.c)
 				}
+			}
+			// move cursor
+			if ev.Rune() == 'w' {
+				c.moveCursor(0, -1)
+			}
+			if ev.Rune() == 's' {
+				c.moveCursor(0, 1)
+			}
+			if ev.Rune() == 'a' {
+				c.moveCursor(-1, 0)
+			}
+			if ev.Rune() == 'd' {
+				c.moveCursor(1, 0)
+			}
+			// the pen only paints where the cursor moves to
+			if c.stroke != nil && strings.ContainsRune("wasd", ev.Rune()) {
+				c.stroke.set(c.m, c.cursor, c.penColor.c)
+			}
+			if ev.Rune() == 'e' || ev.Rune() == ' ' {
+				c.useTool()
+			}
+			// tools
+			if ev.Rune() >= '1' && ev.Rune() < '1'+rune(toolCount) {
+				c.selectTool(tool(ev.Rune() - '1'))
+			}
+			// selection
+			if ev.Rune() == 'm' {
+				c.toggleMarking()
+			}
+			if ev.Rune() == 'M' {
+				c.selectNone()
+			}
+			if ev.Rune() == 'C' {
+				c.copySelection()
+			}
+			if ev.Rune() == 'X' {
+				c.cutSelection()
+			}
+			if ev.Rune() == 'V' {
+				c.paste()
+			}
+			if ev.Rune() == 'Y' {
+				c.copyToClipboard()
+			}
+			if (ev.Key() == tcell.KeyDelete || ev.Key() == tcell.KeyBackspace || ev.Key() == tcell.KeyBackspace2) && !c.selection.Empty() {
+				c.do(clearRect(c.m, c.selection))
+			}
+			if ev.Rune() == 't' {
+				c.penUp()
+				c.cancelShape()
+				c.openFilters()
+			}
+			if ev.Rune() == 'T' {
+				c.penUp()
+				c.cancelShape()
+				c.marking = false
+				c.openTransforms()
+			}
+			if ev.Rune() == 'S' {
+				c.penUp()
+				c.cancelShape()
+				c.marking = false
+				c.openScale()
+			}
+			if ev.Rune() == 'P' {
+				c.penUp()
+				c.cancelShape()
+				c.openPalette()
+			}
+			// frames
+			if ev.Rune() == '<' {
+				c.showFrame(c.m.frame - 1)
+			}
+			if ev.Rune() == '>' {
+				c.showFrame(c.m.frame + 1)
+			}
+			if ev.Rune() == 'F' {
+				c.penUp()
+				c.cancelShape()
+				c.currentState = stateFrames
+			}
+			if ev.Rune() == 'O' {
+				c.onionSkin = !c.onionSkin
+			}
+			if ev.Rune() == 'R' {
+				c.play()
+			}
+			if ev.Rune() == 'L' {
+				c.penUp()
+				c.cancelShape()
+				c.currentState = stateLayers
+			}
+			// pick color
+			if ev.Rune() == 'c' {
+				cl := c.m.At(c.cursor.X, c.cursor.Y)
+				c.penColor = *NewCmdColor(cl, c.paletteSize)
+			}
+			if ev.Rune() == 'z' {
+				c.penUp()
+				c.history.undo(c.m)
+			}
+			if ev.Rune() == 'y' {
+				c.penUp()
+				c.history.redo(c.m)
+			}
+			if ev.Rune() == 'D' {
+				// debug
+				newDrawBox(0, 0, 5, 3).draw(c.s, c.interfaceStyle)
+				drawText(c.s, 0, 10, c.interfaceStyle, "box: "+c.imageBox.String())
+				drawText(c.s, 0, 11, c.interfaceStyle, "canvas: "+c.imageBox.getCanvas().String())
+				drawText(c.s, 0, 12, c.interfaceStyle, fmt.Sprintf("WxH: %dx%d", c.imageBox.getCanvas().Dx(), c.imageBox.getCanvas().Dy()))
+				drawText(c.s, 0, 13, c.interfaceStyle, fmt.Sprintf("Pan XxY: %dx%d", c.pan.X, c.pan.Y))
+				drawText(c.s, 0, 14, c.interfaceStyle, fmt.Sprintf("image XxY: %dx%d", c.imageWidth, c.imageHeight))
+			}
 
-				// value
-				if ev.Rune() == 'l' {
-					c.penColor.changeValue(dirIncrease)
-				}
-				if ev.Rune() == 'o' {
-					c.penColor.changeValue(dirDecrease)
-				}
+			// colors
 
-				// panning
-				if ev.Key() == tcell.KeyUp {
-					c.panY -= 1
-					if c.panY < 0 {
-						c.panY = c.imageHeight - (c.imageBox.getCanvas().Dy() + 1)
-					}
-				}
-				if ev.Key() == tcell.KeyDown {
-					c.panY += 1
-					if c.panY > c.imageHeight-(c.imageBox.getCanvas().Dy()+1) {
-						c.panY = 0
-					}
-				}
-				if ev.Key() == tcell.KeyLeft {
-					c.panX -= 1
-					if c.panX < 0 {
-						c.panX = c.imageWidth - ((c.imageBox.getCanvas().Dx() + 1) / 2)
-					}
-				}
-				if ev.Key() == tcell.KeyRight {
-					c.panX += 1
-					if c.panX > c.imageWidth-((c.imageBox.getCanvas().Dx()+1)/2) {
-						c.panX = 0
-					}
-				}
+			// hue
+			if ev.Rune() == 'j' {
+				c.penColor.changeHue(dirIncrease)
+			}
+			if ev.Rune() == 'u' {
+				c.penColor.changeHue(dirDecrease)
+			}
 
-				if ev.Rune() == 'f' {
-					color := c.m.At(c.cursorX, c.cursorY)
-					if color != c.penColor.c {
-						floodFill(&c.m, image.Pt(c.cursorX, c.cursorY), c.m.At(c.cursorX, c.cursorY), c.penColor.c)
-					}
-				}
+			// saturation
+			if ev.Rune() == 'k' {
+				c.penColor.changeSaturation(dirIncrease)
+			}
+			if ev.Rune() == 'i' {
+				c.penColor.changeSaturation(dirDecrease)
+			}
+
+			// value
+			if ev.Rune() == 'l' {
+				c.penColor.changeValue(dirIncrease)
+			}
+			if ev.Rune() == 'o' {
+				c.penColor.changeValue(dirDecrease)
+			}
+
+			// alpha
+			if ev.Rune() == '.' {
+				c.penColor.changeAlpha(dirIncrease)
+			}
+			if ev.Rune() == ',' {
+				c.penColor.changeAlpha(dirDecrease)
+			}
+			if ev.Rune() == 'E' {
+				c.penColor.toggleEraser()
+			}
+
+			// panning
+			if ev.Key() == tcell.KeyUp {
+				c.scroll(0, -1)
+			}
+			if ev.Key() == tcell.KeyDown {
+				c.scroll(0, 1)
+			}
+			if ev.Key() == tcell.KeyLeft {
+				c.scroll(-1, 0)
+			}
+			if ev.Key() == tcell.KeyRight {
+				c.scroll(1, 0)
+			}
+			// zoom
+			if ev.Rune() == 'v' {
+				c.setRenderMode(c.zoom, !c.halfBlock)
+			}
+			if ev.Rune() == '+' || ev.Rune() == '=' {
+				c.setRenderMode(c.zoom+1, c.halfBlock)
+			}
+			if ev.Rune() == '-' {
+				c.setRenderMode(c.zoom-1, c.halfBlock)
+			}
 
-			} else if c.currentState == stateQuit {
-				if ev.Rune() == 'y' || ev.Rune() == 'Y' {
-					err := c.saveImage(c.fileName, &c.m)
-					if err != nil {
-						return err
-					}
-					break mainLoop
+			if ev.Rune() == 'f' || ev.Rune() == 'g' {
+				opts := c.fill
+				opts.global = ev.Rune() == 'g'
+				c.do(fillAt(c.m, c.cursor, c.penColor.c, opts, c.selectionBounds()))
+			}
+
+			// fill options
+			if ev.Rune() == ']' {
+				c.fill.tolerance = math.Min(1, c.fill.tolerance+toleranceStep)
+			}
+			if ev.Rune() == '[' {
+				c.fill.tolerance = math.Max(0, c.fill.tolerance-toleranceStep)
+			}
+			if ev.Rune() == 'n' {
+				c.fill.diagonal = !c.fill.diagonal
+			}
+			if ev.Rune() == 'N' {
+				c.fill.space = (c.fill.space + 1) % 2
+			}
+
+			if c.marking {
+				c.updateMarking()
+			}
+			// the shape follows the cursor, moving with alt constrains it
+			if c.shape != nil {
+				constrain := c.shape.constrain
+				if strings.ContainsRune("wasd", ev.Rune()) {
+					constrain = ev.Modifiers()&tcell.ModAlt != 0
 				}
-				if ev.Rune() == 'n' || ev.Rune() == 'N' || ev.Key() == tcell.KeyEscape {
-					c.currentState = stateDrawing
-					c.s.Clear()
+				c.updateShape(constrain)
+			}
+
+		} else if c.currentState == stateFilters {
+			c.handleFilterKey(ev)
+		} else if c.currentState == stateLayers {
+			c.handleLayerKey(ev)
+		} else if c.currentState == stateFloating {
+			c.handleFloatingKey(ev)
+		} else if c.currentState == stateTransform {
+			c.handleTransformKey(ev)
+		} else if c.currentState == stateScale {
+			c.handleScaleKey(ev)
+		} else if c.currentState == statePalette {
+			c.handlePaletteKey(ev)
+		} else if c.currentState == stateFrames {
+			c.handleFramesKey(ev)
+		} else if c.currentState == statePlayback {
+			c.handlePlaybackKey(ev)
+		} else if c.currentState == stateQuit {
+			if ev.Rune() == 'y' || ev.Rune() == 'Y' {
+				err := c.save()
+				if err != nil {
+					return false, err
 				}
+				return true, nil
+			}
+			// quit without saving
+			if ev.Rune() == 'd' || ev.Rune() == 'D' {
+				return true, nil
+			}
+			if ev.Rune() == 'n' || ev.Rune() == 'N' || ev.Key() == tcell.KeyEscape {
+				c.currentState = stateDrawing
+				c.s.Clear()
 			}
 		}
-		c.draw()
+		// undo, redo and transforms can change the image size
+		c.syncBounds()
+	}
+	c.scheduleAnts()
+	return false, nil
+}
+
+// do adds an applied command to the history.
+func (c *CmdPxl) do(cmd command) {
+	if cmd != nil {
+		c.splitStroke()
+		c.history.push(cmd)
+	}
+}
+
+// save stores the image, or the whole editor state for project files.
+func (c *CmdPxl) save() error {
+	if isProjectFile(c.fileName) {
+		return saveProject(c.fileName, c.project())
+	}
+	return c.saveImage(c.fileName, c.m)
+}
+
+// project returns the editor state to be stored in a project file.
+func (c *CmdPxl) project() *project {
+	return &project{
+		m:           c.m,
+		penColor:    c.penColor.c,
+		paletteSize: c.paletteSize,
+		fill:        c.fill,
+		cursor:      c.cursor,
+		pan:         c.pan,
+		history:     c.history,
+	}
+}
+
+// restoreProject restores the editor state loaded from a project file.
+func (c *CmdPxl) restoreProject(p *project) {
+	c.m = p.m
+	b := p.m.Bounds()
+	c.imageWidth = b.Max.X
+	c.imageHeight = b.Max.Y
+	if p.paletteSize > 0 {
+		c.paletteSize = p.paletteSize
+	}
+	c.penColor = *NewCmdColor(p.penColor, c.paletteSize)
+	c.fill = p.fill
+	c.cursor = p.cursor
+	c.pan = p.pan
+	c.history = p.history
+}
+
+// penUp ends continuous drawing and stores the stroke as a single history
+// item.
+func (c *CmdPxl) penUp() {
+	if c.stroke != nil && !c.stroke.empty() {
+		c.history.push(c.stroke)
+	}
+	c.stroke = nil
+}
+
+// splitStroke stores the pixels painted with the pen so far, so commands
+// made while the pen is down are undone before them. The pen stays down.
+func (c *CmdPxl) splitStroke() {
+	if c.stroke != nil && !c.stroke.empty() {
+		c.history.push(c.stroke)
+		c.stroke = newPixelCommand()
+	}
+}
+
+func (c *CmdPxl) openFilters() {
+	c.filters = getFilters()
+	names := make([]string, len(c.filters))
+	for i, f := range c.filters {
+		names[i] = f.Name()
+	}
+	c.filterMenu = newMenu("Filters", names)
+	c.currentState = stateFilters
+	c.updateFilterPreview()
+}
+
+func (c *CmdPxl) closeFilters() {
+	c.filters = nil
+	c.filterMenu = nil
+	c.preview = nil
+	c.currentState = stateDrawing
+	c.s.Clear()
+}
+
+func (c *CmdPxl) selectedFilter() Filter {
+	return c.filters[c.filterMenu.selected]
+}
+
+func (c *CmdPxl) updateFilterPreview() {
+	c.preview = applyFilter(c.m.activeLayerImage(), c.selectedFilter(), c.selectionBounds())
+}
+
+func (c *CmdPxl) handleFilterKey(ev *tcell.EventKey) {
+	switch {
+	case ev.Key() == tcell.KeyEscape || ev.Rune() == 't' || ev.Rune() == 'x':
+		c.closeFilters()
+	case ev.Key() == tcell.KeyEnter || ev.Rune() == 'e' || ev.Rune() == ' ':
+		c.do(commitLayer(c.m, c.preview))
+		c.closeFilters()
+	case ev.Key() == tcell.KeyUp || ev.Rune() == 'w':
+		c.filterMenu.move(dirDecrease)
+		c.updateFilterPreview()
+	case ev.Key() == tcell.KeyDown || ev.Rune() == 's':
+		c.filterMenu.move(dirIncrease)
+		c.updateFilterPreview()
+	case ev.Key() == tcell.KeyLeft || ev.Rune() == 'a':
+		if f, ok := c.selectedFilter().(adjustableFilter); ok {
+			f.Adjust(dirDecrease)
+			c.updateFilterPreview()
+		}
+	case ev.Key() == tcell.KeyRight || ev.Rune() == 'd':
+		if f, ok := c.selectedFilter().(adjustableFilter); ok {
+			f.Adjust(dirIncrease)
+			c.updateFilterPreview()
+		}
 	}
-	return nil
+}
+
+func (c *CmdPxl) drawFilterMenu() *drawBox {
+	param := "-"
+	if f, ok := c.selectedFilter().(adjustableFilter); ok {
+		param = f.Param()
+	}
+	return c.filterMenu.draw(c.s, 0, 0, c.interfaceStyle,
+		"[a/d] amount: "+param,
+		"[e] apply [esc] cancel",
+	)
 }
 
 func (c *CmdPxl) draw() {
@@ -255,11 +599,29 @@ func (c *CmdPxl) draw() {
 	if c.currentState == stateQuit {
 		c.drawExitConfirmation()
 	}
+	if c.currentState == stateFilters {
+		c.drawFilterMenu()
+	}
+	if c.currentState == stateLayers {
+		c.drawLayerPanel()
+	}
+	if c.currentState == stateTransform {
+		c.drawTransformMenu()
+	}
+	if c.currentState == stateScale {
+		c.drawScaleMenu()
+	}
+	if c.currentState == statePalette {
+		c.drawPalettePanel()
+	}
+	if c.currentState == stateFrames {
+		c.drawFramesPanel()
+	}
 }
 
 func (c *CmdPxl) drawExitConfirmation() *drawBox {
-	confirmation := "Do you want to exit? [y/n]"
-	dBox := newDrawBox(0, 0, len(confirmation)+2+borderSize*2, borderSize*2+1).draw(c.s, c.interfaceStyle)
+	confirmation := "Save and exit? [y] save [d] discard [n] cancel"
+	dBox := newDrawBox(0, 0, len(confirmation)+2+borderSize*2, borderSize*2+1).clear(c.s, c.interfaceStyle).draw(c.s, c.interfaceStyle)
 	p := dBox.getPoint(1, 0)
 	drawText(c.s, p.X, p.Y, c.interfaceStyle, confirmation)
 	return dBox
@@ -268,55 +630,120 @@ func (c *CmdPxl) drawExitConfirmation() *drawBox {
 func (c *CmdPxl) getImageBox() *drawBox {
 	offsetY := 5
 	interfaceRows := 12
-	width := min(c.imageWidth*2+2, c.screenWidth)
-	height := min(c.imageHeight+2, c.screenHeight-interfaceRows)
+	cols, rows := c.getRenderMode().cells(c.imageWidth, c.imageHeight)
+	width := min(cols+2, c.screenWidth)
+	height := min(rows+2, c.screenHeight-interfaceRows)
 
 	x := c.paddingX
 	y := offsetY + c.paddingY
 	return newDrawBox(x, y, width, height)
 }
 
+// layout positions the interface for the current screen size and render
+// mode.
+func (c *CmdPxl) layout() {
+	cols, _ := c.getRenderMode().cells(c.imageWidth, c.imageHeight)
+	c.paddingX = max(0, (c.screenWidth-max(60, cols))/2)
+
+	c.maxDrawWidth = c.screenWidth - 2*borderSize
+	c.maxDrawHeight = c.screenHeight - 13 // chrome
+
+	c.imageBox = c.getImageBox()
+	c.pan = c.viewport().scrollTo(c.cursor).pan
+}
+
 func (c *CmdPxl) drawImage(dBox *drawBox) {
 	canvas := dBox.getCanvas()
-	const pixelWidth = 2
-	xBoundary := min(c.imageWidth*2, canvas.Dx()/pixelWidth+1)
-	yBoundary := min(c.imageHeight, canvas.Dy()+1)
-	for y := 0; y < yBoundary; y++ {
-		for x := 0; x < xBoundary; x++ {
-			imageColor := c.m.At(x+c.panX, y+c.panY)
-			bgColor := tcell.FromImageColor(imageColor)
-			style := tcell.StyleDefault.Background(bgColor)
-			p := dBox.getPoint(x*2, y)
-			if c.cursorX == x && c.cursorY == y {
-				style = style.Foreground(tcell.FromImageColor(getFgColor(imageColor)))
-				c.s.SetContent(p.X, p.Y, '[', nil, style)
-				c.s.SetContent(p.X+1, p.Y, ']', nil, style)
-			} else {
-				c.s.SetContent(p.X, p.Y, ' ', nil, style)
-				c.s.SetContent(p.X+1, p.Y, ' ', nil, style)
+	v := c.viewport()
+	for cy := 0; cy <= canvas.Dy(); cy++ {
+		for cx := 0; cx <= canvas.Dx(); cx++ {
+			top, bottom := v.cellPixels(cx, cy)
+			if !top.In(v.bounds) {
+				continue
+			}
+			p := dBox.getPoint(cx, cy)
+			topColor := displayColor(top, c.onionColor(top, c.m.atWith(top, c.preview)))
+			if top == bottom {
+				// the cell shows a single pixel
+				style := tcell.StyleDefault.Background(tcell.FromImageColor(topColor))
+				r := ' '
+				if top == c.cursor {
+					style = style.Foreground(tcell.FromImageColor(getFgColor(topColor)))
+					r = v.mode.cursorRune(cx % v.mode.cols)
+				} else if ant, ok := c.antColor(top); ok {
+					style = style.Foreground(tcell.FromImageColor(ant))
+					r = '•'
+				}
+				c.s.SetContent(p.X, p.Y, r, nil, style)
+				continue
+			}
+			// the upper half block shows the top pixel in the foreground
+			// and the bottom pixel in the background
+			if top == c.cursor {
+				topColor = getFgColor(topColor)
+			} else if ant, ok := c.antColor(top); ok {
+				topColor = ant
+			}
+			style := tcell.StyleDefault.Foreground(tcell.FromImageColor(topColor))
+			if bottom.In(v.bounds) {
+				bottomColor := displayColor(bottom, c.onionColor(bottom, c.m.atWith(bottom, c.preview)))
+				if bottom == c.cursor {
+					bottomColor = getFgColor(bottomColor)
+				} else if ant, ok := c.antColor(bottom); ok {
+					bottomColor = ant
+				}
+				style = style.Background(tcell.FromImageColor(bottomColor))
 			}
+			c.s.SetContent(p.X, p.Y, '▀', nil, style)
 		}
 	}
 }
 
 func (c *CmdPxl) drawInterface() {
-	drawText(c.s, c.paddingX, 1, c.interfaceStyle, fmt.Sprintf("CMDPXL-GO: %s (%dx%d) | pos: %03d,%03d", c.fileName, c.imageWidth, c.imageHeight, c.cursorX, c.cursorY))
-	p := newDrawBox(c.paddingX, c.screenHeight-4, 100, 4).getPoint(0, 0)
-	drawText(c.s, p.X, p.Y, c.interfaceStyle, "[wasd] move | [e] draw | [f] fill | [arrows] pan")
-	drawText(c.s, p.X, p.Y+1, c.interfaceStyle, "[z] undo | [t] filters | [x] quit")
+	pen := "up"
+	if c.stroke != nil {
+		pen = "down"
+	}
+	drawText(c.s, c.paddingX, 0, c.interfaceStyle, fmt.Sprintf("%-*s", max(0, c.screenWidth-c.paddingX), c.message))
+	drawText(c.s, c.paddingX, 1, c.interfaceStyle, fmt.Sprintf("CMDPXL-GO: %s (%dx%d) | pos: %03d,%03d | pen: %-4s | layer: %-12s", c.fileName, c.imageWidth, c.imageHeight, c.cursor.X, c.cursor.Y, pen, c.m.activeLayer().name))
+	onion := "off"
+	if c.onionSkin {
+		onion = "on"
+	}
+	play := "play"
+	if c.currentState == statePlayback {
+		play = "stop"
+	}
+	// every line fits into the 69 columns left at 80 columns
+	hints := []string{
+		fmt.Sprintf("%d/%d %-*s | [</>] frame | [F] frames | [R] %s | [x] quit", c.m.frame+1, c.m.frameCount(), timelineLength, c.timeline(), play),
+		fmt.Sprintf("[wasd] move | [arrows] pan | [+/-] zoom: %-4s | [v] half-block", c.getRenderMode()),
+		"[e] draw | [p] pen up/down | [c] pick | [z/y] undo/redo | [del] clear",
+		fmt.Sprintf("[1-6] tool: %-16s | [alt+wasd] constrain | [O] onion: %-3s", c.tool, onion),
+		fmt.Sprintf("[f/g] fill/replace | [[/]] tolerance: %.2f | [n] %s | [N] %s", c.fill.tolerance, c.fill.connectivity(), c.fill.space),
+		"[m] select | [M] none | [C/X/V] copy/cut/paste | [Y] clipboard",
+		"[t] filters | [T] transform | [S] scale | [L] layers | [P] palette",
+	}
+	p := newDrawBox(c.paddingX, c.screenHeight-len(hints)-1, 100, len(hints)).getPoint(0, 0)
+	for i, hint := range hints {
+		drawText(c.s, p.X, p.Y+i, c.interfaceStyle, hint)
+	}
 }
 
-func (c *CmdPxl) drawColorSelect() {
-	sectionWidth := 12
+func (c *CmdPxl) getColorSelectBox() *drawBox {
 	boxHeight := 4
-	numBoxes := 4
+	numBoxes := 5
 	x1 := c.paddingX
 	y1 := c.paddingY + 1
+	return newDrawBox(x1, y1, numBoxes*sectionWidth+borderSize, boxHeight)
+}
+
+func (c *CmdPxl) drawColorSelect() {
 	// box
-	dBox := newDrawBox(x1, y1, numBoxes*sectionWidth+borderSize, boxHeight).draw(c.s, c.interfaceStyle)
+	dBox := c.getColorSelectBox().draw(c.s, c.interfaceStyle)
 	p := dBox.getPoint(0, 0)
 	// instructions
-	instructions := "[u/j]: hue  [i/k]: sat  [o/l]: val  current"
+	instructions := "[u/j]: hue  [i/k]: sat  [o/l]: val  [,/.]:alpha [E]: eraser"
 	drawText(c.s, p.X, p.Y, c.interfaceStyle, instructions)
 
 	// Color selection
@@ -363,15 +790,38 @@ func (c *CmdPxl) drawColorSelect() {
 		c.s.SetContent(p.X+offset, p.Y, text, nil, style)
 	}
 
-	// Current color
-	style := tcell.StyleDefault.Background((tcell.FromImageColor(c.penColor.c)))
+	// Alpha over the checkerboard
 	p = dBox.getPoint(sectionWidth*3, 1)
 	c.s.SetContent(p.X-1, p.Y-2, '┬', nil, c.interfaceStyle)
 	c.s.SetContent(p.X-1, p.Y-1, '│', nil, c.interfaceStyle)
 	c.s.SetContent(p.X-1, p.Y+0, '│', nil, c.interfaceStyle)
 	c.s.SetContent(p.X-1, p.Y+1, '┴', nil, c.interfaceStyle)
+	opaque := colorful.Hsv(c.penColor.hue, c.penColor.saturation, c.penColor.value)
+	for offset, alpha := range c.penColor.alphaPalette {
+		cl := displayColor(image.Pt(offset, 0), withAlpha(opaque, alpha))
+		style := tcell.StyleDefault.Background(tcell.FromImageColor(cl))
+		text := ' '
+		if offset == c.penColor.alphaPaletteIndex {
+			style = style.Foreground(tcell.FromImageColor(getFgColor(cl)))
+			text = '●'
+		}
+		c.s.SetContent(p.X+offset, p.Y, text, nil, style)
+	}
 
-	drawText(c.s, p.X, p.Y, style, strings.Repeat(" ", c.paletteSize))
+	// Current color
+	p = dBox.getPoint(sectionWidth*4, 1)
+	c.s.SetContent(p.X-1, p.Y-2, '┬', nil, c.interfaceStyle)
+	c.s.SetContent(p.X-1, p.Y-1, '│', nil, c.interfaceStyle)
+	c.s.SetContent(p.X-1, p.Y+0, '│', nil, c.interfaceStyle)
+	c.s.SetContent(p.X-1, p.Y+1, '┴', nil, c.interfaceStyle)
+	if c.penColor.isTransparent() {
+		drawText(c.s, p.X, p.Y, c.interfaceStyle, fmt.Sprintf("%-*s", c.paletteSize, "eraser"))
+		return
+	}
+	for offset := 0; offset < c.paletteSize; offset++ {
+		cl := displayColor(image.Pt(offset, 0), c.penColor.c)
+		c.s.SetContent(p.X+offset, p.Y, ' ', nil, tcell.StyleDefault.Background(tcell.FromImageColor(cl)))
+	}
 }
 
 func drawText(s tcell.Screen, x, y int, style tcell.Style, text string) {
@@ -417,6 +867,11 @@ func mod(a, b int) int {
 	return a
 }
 
+// wrap returns a modulo b in the range 0 to b-1, also for negative a.
+func wrap(a, b int) int {
+	return (a%b + b) % b
+}
+
 func getFgColor(c color.Color) color.Color {
 	// https://socketloop.com/tutorials/golang-find-relative-luminance-or-color-brightness
 	red, green, blue, _ := c.RGBA()
@@ -426,11 +881,3 @@ func getFgColor(c color.Color) color.Color {
 	}
 	return color.Black
 }
-
-func getLayerFromHistory(h []historyItem) layer {
-	l := make(layer)
-	for _, hi := range h {
-		l[hi.point] = hi.color
-	}
-	return l
-}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"image"
+	"image/color"
 	"testing"
 
 	"github.com/lucasb-eyer/go-colorful"
@@ -34,3 +36,77 @@ func Test_getHuePaletteIndex(t *testing.T) {
 		})
 	}
 }
+
+func Test_NewCmdColor(t *testing.T) {
+	tests := []struct {
+		name            string
+		color           color.Color
+		wantHue         int
+		wantSaturation  int
+		wantValue       int
+		wantAlpha       int
+		wantTransparent bool
+	}{
+		{
+			"snaps to green",
+			color.RGBA{0, 255, 0, 255},
+			3,
+			10,
+			10,
+			10,
+			false,
+		},
+		{
+			"picks black",
+			color.RGBA{0, 0, 0, 255},
+			0,
+			0,
+			0,
+			10,
+			false,
+		},
+		{
+			"keeps the alpha of a semi-transparent pixel",
+			color.NRGBA{0, 0, 255, 128},
+			7,
+			10,
+			10,
+			5,
+			false,
+		},
+		{
+			"picks transparent pixel as eraser",
+			color.RGBA{},
+			0,
+			0,
+			0,
+			0,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewCmdColor(tt.color, 11)
+			if got.huePaletteIndex != tt.wantHue || got.saturationPaletteIndex != tt.wantSaturation || got.valuePaletteIndex != tt.wantValue {
+				t.Errorf("NewCmdColor() indices = %d,%d,%d, want %d,%d,%d", got.huePaletteIndex, got.saturationPaletteIndex, got.valuePaletteIndex, tt.wantHue, tt.wantSaturation, tt.wantValue)
+			}
+			if got.alphaPaletteIndex != tt.wantAlpha {
+				t.Errorf("NewCmdColor().alphaPaletteIndex = %d, want %d", got.alphaPaletteIndex, tt.wantAlpha)
+			}
+			if got.isTransparent() != tt.wantTransparent {
+				t.Errorf("NewCmdColor().isTransparent() = %v, want %v", got.isTransparent(), tt.wantTransparent)
+			}
+			if !sameColor(got.c, tt.color) {
+				t.Errorf("NewCmdColor().c = %v, want %v", got.c, tt.color)
+			}
+		})
+	}
+}
+
+func Test_checkerColor(t *testing.T) {
+	for x := 0; x < 6; x++ {
+		if sameColor(checkerColor(image.Pt(x, 0)), checkerColor(image.Pt(x+1, 0))) {
+			t.Errorf("Expected neighbours %d and %d to alternate", x, x+1)
+		}
+	}
+}
@@ -1,12 +1,27 @@
 package main
 
 import (
+	"image"
 	"image/color"
 	"math"
 
 	"github.com/lucasb-eyer/go-colorful"
 )
 
+type colorSpace int
+
+const (
+	colorSpaceRGB colorSpace = iota
+	colorSpaceLab
+)
+
+func (cs colorSpace) String() string {
+	if cs == colorSpaceLab {
+		return "lab"
+	}
+	return "rgb"
+}
+
 type cmdColor struct {
 	c color.Color
 
@@ -24,11 +39,20 @@ type cmdColor struct {
 
 	valuePaletteIndex int
 	valuePalette      []colorful.Color
+
+	alpha             float64
+	alphaPaletteIndex int
+	alphaPalette      []float64
+
+	// opaqueAlphaIndex restores the alpha when the eraser is toggled off
+	opaqueAlphaIndex int
 }
 
 func NewCmdColor(c color.Color, paletteSize int) *cmdColor {
-	cl, _ := colorful.MakeColor(c)
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	cl, _ := colorful.MakeColor(color.NRGBA{n.R, n.G, n.B, 0xff})
 	h, s, v := cl.Hsv()
+	alpha := float64(n.A) / 0xff
 	huePalette := getHuePalette(paletteSize)
 	huePaletteIndex := getHuePaletteIndex(h, huePalette)
 
@@ -38,6 +62,9 @@ func NewCmdColor(c color.Color, paletteSize int) *cmdColor {
 	valuePalette := getValuePalette(h, s, paletteSize)
 	valuePaletteIndex := getValuePaletteIndex(v, valuePalette)
 
+	alphaPalette := getAlphaPalette(paletteSize)
+	alphaPaletteIndex := getClosestIndex(alpha, alphaPalette)
+
 	return &cmdColor{
 		c:                      c,
 		hue:                    h,
@@ -50,9 +77,19 @@ func NewCmdColor(c color.Color, paletteSize int) *cmdColor {
 		saturationPaletteIndex: saturationPaletteIndex,
 		valuePalette:           valuePalette,
 		valuePaletteIndex:      valuePaletteIndex,
+		alpha:                  alpha,
+		alphaPalette:           alphaPalette,
+		alphaPaletteIndex:      alphaPaletteIndex,
+		opaqueAlphaIndex:       paletteSize - 1,
 	}
 }
 
+// isTransparent reports if the color erases pixels.
+func (cc *cmdColor) isTransparent() bool {
+	_, _, _, a := cc.c.RGBA()
+	return a == 0
+}
+
 func getHuePalette(items int) []colorful.Color {
 	const (
 		saturation = 1.0
@@ -106,9 +143,14 @@ func (cc *cmdColor) changeHue(dir direction) {
 			newIndex = 0
 		}
 	}
-	cl := cc.huePalette[newIndex]
+	cc.selectHue(newIndex)
+}
+
+// selectHue picks the hue at index from the hue palette.
+func (cc *cmdColor) selectHue(index int) {
+	cl := cc.huePalette[index]
 	newHue, _, _ := cl.Hsv()
-	cc.c = colorful.Hsv(newHue, cc.saturation, cc.value)
+	cc.c = withAlpha(colorful.Hsv(newHue, cc.saturation, cc.value), cc.alpha)
 	cc.hue = newHue
 	cc.huePaletteIndex = getHuePaletteIndex(newHue, cc.huePalette)
 	cc.saturationPalette = getSaturationPalette(newHue, cc.paletteSize)
@@ -150,9 +192,14 @@ func (cc *cmdColor) changeSaturation(dir direction) {
 			newIndex = 0
 		}
 	}
-	cl := cc.saturationPalette[newIndex]
+	cc.selectSaturation(newIndex)
+}
+
+// selectSaturation picks the saturation at index from the saturation palette.
+func (cc *cmdColor) selectSaturation(index int) {
+	cl := cc.saturationPalette[index]
 	_, newSaturation, _ := cl.Hsv()
-	cc.c = colorful.Hsv(cc.hue, newSaturation, cc.value)
+	cc.c = withAlpha(colorful.Hsv(cc.hue, newSaturation, cc.value), cc.alpha)
 	cc.saturation = newSaturation
 	cc.saturationPaletteIndex = getSaturationPaletteIndex(newSaturation, cc.saturationPalette)
 	cc.valuePalette = getValuePalette(cc.hue, cc.saturation, cc.paletteSize)
@@ -192,9 +239,113 @@ func (cc *cmdColor) changeValue(dir direction) {
 			newIndex = 0
 		}
 	}
-	cl := cc.valuePalette[newIndex]
+	cc.selectValue(newIndex)
+}
+
+// selectValue picks the value at index from the value palette.
+func (cc *cmdColor) selectValue(index int) {
+	cl := cc.valuePalette[index]
 	_, _, newValue := cl.Hsv()
-	cc.c = colorful.Hsv(cc.hue, cc.saturation, newValue)
+	cc.c = withAlpha(colorful.Hsv(cc.hue, cc.saturation, newValue), cc.alpha)
 	cc.value = newValue
 	cc.valuePaletteIndex = getValuePaletteIndex(newValue, cc.valuePalette)
 }
+
+// getAlphaPalette returns evenly spaced alpha values from fully transparent
+// to opaque.
+func getAlphaPalette(items int) []float64 {
+	result := make([]float64, items)
+	for i := 0; i < items; i++ {
+		result[i] = float64(i) / float64(max(1, items-1))
+	}
+	return result
+}
+
+func (cc *cmdColor) changeAlpha(dir direction) {
+	newIndex := cc.alphaPaletteIndex - 1
+	if dir == dirIncrease {
+		newIndex = cc.alphaPaletteIndex + 1
+	}
+	cc.selectAlpha(min(max(newIndex, 0), cc.paletteSize-1))
+}
+
+// selectAlpha picks the alpha at index from the alpha palette.
+func (cc *cmdColor) selectAlpha(index int) {
+	cc.alpha = cc.alphaPalette[index]
+	cc.alphaPaletteIndex = index
+	cc.c = withAlpha(colorful.Hsv(cc.hue, cc.saturation, cc.value), cc.alpha)
+}
+
+// toggleEraser switches between a fully transparent pen and the previous
+// alpha.
+func (cc *cmdColor) toggleEraser() {
+	if cc.alphaPaletteIndex > 0 {
+		cc.opaqueAlphaIndex = cc.alphaPaletteIndex
+		cc.selectAlpha(0)
+		return
+	}
+	cc.selectAlpha(max(cc.opaqueAlphaIndex, 1))
+}
+
+// withAlpha returns the color with the given opacity between 0 and 1.
+func withAlpha(cl colorful.Color, alpha float64) color.Color {
+	r, g, b := cl.RGB255()
+	return color.NRGBA{r, g, b, uint8(math.Round(alpha * 0xff))}
+}
+
+// checkerColor returns the color of the checkerboard shown behind
+// transparent pixels.
+func checkerColor(p image.Point) color.NRGBA {
+	if wrap(p.X+p.Y, 2) == 0 {
+		return color.NRGBA{0x99, 0x99, 0x99, 0xff}
+	}
+	return color.NRGBA{0x66, 0x66, 0x66, 0xff}
+}
+
+// displayColor blends c over the checkerboard so that transparent pixels can
+// be told apart on the terminal.
+func displayColor(p image.Point, c color.Color) color.Color {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	if n.A == 0xff {
+		return n
+	}
+	bg := checkerColor(p)
+	blend := func(fg, bg uint8) uint8 {
+		return uint8((int(fg)*int(n.A) + int(bg)*(0xff-int(n.A)) + 0x7f) / 0xff)
+	}
+	return color.NRGBA{blend(n.R, bg.R), blend(n.G, bg.G), blend(n.B, bg.B), 0xff}
+}
+
+// colorDistance returns the distance between two colors in the range 0 to 1.
+// Differences in alpha are taken into account as well.
+func colorDistance(c1, c2 color.Color, space colorSpace) float64 {
+	_, _, _, a1 := c1.RGBA()
+	_, _, _, a2 := c2.RGBA()
+	alphaDistance := math.Abs(float64(a1)-float64(a2)) / 0xffff
+	if a1 == 0 || a2 == 0 {
+		// the color of transparent pixels does not matter
+		return alphaDistance
+	}
+	cl1, _ := colorful.MakeColor(c1)
+	cl2, _ := colorful.MakeColor(c2)
+	var d float64
+	if space == colorSpaceLab {
+		d = cl1.DistanceLab(cl2)
+	} else {
+		d = cl1.DistanceRgb(cl2) / math.Sqrt(3)
+	}
+	return math.Min(1, math.Max(d, alphaDistance))
+}
+
+func sameColor(c1, c2 color.Color) bool {
+	r1, g1, b1, a1 := c1.RGBA()
+	r2, g2, b2, a2 := c2.RGBA()
+	return r1 == r2 && g1 == g2 && b1 == b2 && a1 == a2
+}
+
+func colorMatches(c, target color.Color, opts fillOptions) bool {
+	if sameColor(c, target) {
+		return true
+	}
+	return opts.tolerance > 0 && colorDistance(c, target, opts.space) <= opts.tolerance
+}
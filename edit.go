@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// The editing operations below are shared by the interactive editor and the
+// script runner. Every operation returns the command to add to the history,
+// or nil if nothing was changed.
+
+// paintPixel paints a single pixel on the current layer.
+func paintPixel(m *layeredImage, p image.Point, c color.Color) command {
+	if !p.In(m.Bounds()) {
+		return nil
+	}
+	cmd := newPixelCommand()
+	cmd.set(m, p, c)
+	return cmd
+}
+
+// fillAt flood fills the area connected to p with c without leaving r.
+func fillAt(m *layeredImage, p image.Point, c color.Color, opts fillOptions, r image.Rectangle) command {
+	if !p.In(r.Intersect(m.Bounds())) {
+		return nil
+	}
+	fromColor := m.At(p.X, p.Y)
+	if sameColor(fromColor, c) {
+		return nil
+	}
+	rec := newRecorder(m)
+	floodFill(clippedImage{rec, r}, p, fromColor, c, opts)
+	if rec.cmd.empty() {
+		return nil
+	}
+	return rec.cmd
+}
+
+// commitLayer paints all pixels of l which differ from the current layer.
+func commitLayer(m *layeredImage, l layer) command {
+	cmd := newPixelCommand()
+	for _, pt := range l.points() {
+		if !sameColor(m.activeLayer().at(pt), l[pt]) {
+			cmd.set(m, pt, l[pt])
+		}
+	}
+	if cmd.empty() {
+		return nil
+	}
+	return cmd
+}
+
+// clearRect makes the pixels of the current layer within r transparent.
+func clearRect(m *layeredImage, r image.Rectangle) command {
+	cmd := newPixelCommand()
+	r = r.Intersect(m.bounds)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if pt := image.Pt(x, y); m.activeLayer().at(pt).A != 0 {
+				cmd.set(m, pt, color.Transparent)
+			}
+		}
+	}
+	if cmd.empty() {
+		return nil
+	}
+	return cmd
+}
+
+// drawShape paints the shape on the current layer.
+func drawShape(m *layeredImage, s shape, c color.Color) command {
+	return commitLayer(m, s.layer(m.Bounds(), c))
+}
+
+// filterLayer applies the filter to the pixels of the current layer within r.
+func filterLayer(m *layeredImage, f Filter, r image.Rectangle) command {
+	return commitLayer(m, applyFilter(m.activeLayerImage(), f, r))
+}
+
+// anchor is the part of the image kept in place when the canvas is resized.
+type anchor int
+
+const (
+	anchorTopLeft anchor = iota
+	anchorTop
+	anchorTopRight
+	anchorLeft
+	anchorCenter
+	anchorRight
+	anchorBottomLeft
+	anchorBottom
+	anchorBottomRight
+	anchorCount
+)
+
+var anchorNames = []string{
+	"top-left", "top", "top-right",
+	"left", "center", "right",
+	"bottom-left", "bottom", "bottom-right",
+}
+
+func (a anchor) String() string {
+	return anchorNames[a]
+}
+
+func parseAnchor(name string) (anchor, error) {
+	for i, n := range anchorNames {
+		if n == name {
+			return anchor(i), nil
+		}
+	}
+	return anchorTopLeft, fmt.Errorf("unknown anchor %s", name)
+}
+
+// offset returns how far the pixels move when the size changes by d.
+func (a anchor) offset(d image.Point) image.Point {
+	return image.Pt(d.X*(int(a)%3)/2, d.Y*(int(a)/3)/2)
+}
+
+// transformImage moves the pixels of every layer with fn and changes the
+// image bounds to b, pixels moved outside of b are dropped.
+func transformImage(m *layeredImage, b image.Rectangle, fn func(p image.Point) image.Point) command {
+	return changeLayers(m, func(m *layeredImage) bool {
+		for _, l := range m.allLayers() {
+			pixels := image.NewNRGBA(b)
+			r := l.pixels.Rect
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					p := image.Pt(x, y)
+					if c := l.at(p); c.A != 0 {
+						p = fn(p)
+						pixels.SetNRGBA(p.X, p.Y, c)
+					}
+				}
+			}
+			l.pixels = pixels
+		}
+		m.bounds = b
+		return true
+	})
+}
+
+// resizeCanvas changes the size of the image keeping the anchored part in
+// place, pixels outside of the new canvas are dropped.
+func resizeCanvas(m *layeredImage, width, height int, a anchor) command {
+	b := image.Rect(0, 0, width, height)
+	if b == m.bounds {
+		return nil
+	}
+	offset := a.offset(b.Size().Sub(m.bounds.Size()))
+	return transformImage(m, b, func(p image.Point) image.Point {
+		return p.Sub(m.bounds.Min).Add(offset)
+	})
+}
+
+// flipImage mirrors the image horizontally or vertically.
+func flipImage(m *layeredImage, horizontal bool) command {
+	b := m.bounds
+	return transformImage(m, b, func(p image.Point) image.Point {
+		if horizontal {
+			return image.Pt(b.Min.X+b.Max.X-1-p.X, p.Y)
+		}
+		return image.Pt(p.X, b.Min.Y+b.Max.Y-1-p.Y)
+	})
+}
+
+// rotateImage rotates the image by 90°, the width and height are swapped.
+func rotateImage(m *layeredImage, clockwise bool) command {
+	size := m.bounds.Size()
+	origin := m.bounds.Min
+	return transformImage(m, image.Rect(0, 0, size.Y, size.X), func(p image.Point) image.Point {
+		p = p.Sub(origin)
+		if clockwise {
+			return image.Pt(size.Y-1-p.Y, p.X)
+		}
+		return image.Pt(p.Y, size.X-1-p.X)
+	})
+}
+
+// cropImage reduces the image to r.
+func cropImage(m *layeredImage, r image.Rectangle) command {
+	r = r.Intersect(m.bounds)
+	if r.Empty() || r == m.bounds {
+		return nil
+	}
+	return transformImage(m, image.Rect(0, 0, r.Dx(), r.Dy()), func(p image.Point) image.Point {
+		return p.Sub(r.Min)
+	})
+}
+
+// trimImage crops the transparent borders of all layers of all frames.
+func trimImage(m *layeredImage) command {
+	var r image.Rectangle
+	for _, l := range m.allLayers() {
+		b := l.pixels.Rect
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if p := image.Pt(x, y); l.at(p).A != 0 {
+					r = r.Union(image.Rectangle{p, p.Add(image.Pt(1, 1))})
+				}
+			}
+		}
+	}
+	return cropImage(m, r)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// pixelString renders the current layer as text, # marks painted pixels.
+func pixelString(m *layeredImage) string {
+	b := m.Bounds()
+	lines := make([]string, 0, b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var line strings.Builder
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if m.activeLayer().at(image.Pt(x, y)).A != 0 {
+				line.WriteByte('#')
+			} else {
+				line.WriteByte('.')
+			}
+		}
+		lines = append(lines, line.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// paintedPixels returns the number of pixels painted on the layer.
+func paintedPixels(l *imageLayer) int {
+	n := 0
+	for i := 3; i < len(l.pixels.Pix); i += 4 {
+		if l.pixels.Pix[i] != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func Test_transforms(t *testing.T) {
+	tests := []struct {
+		name      string
+		transform func(m *layeredImage) command
+		want      []string
+	}{
+		{"flip horizontal", func(m *layeredImage) command { return flipImage(m, true) }, []string{
+			"..##",
+			"...#",
+			"....",
+		}},
+		{"flip vertical", func(m *layeredImage) command { return flipImage(m, false) }, []string{
+			"....",
+			"#...",
+			"##..",
+		}},
+		{"rotate clockwise", func(m *layeredImage) command { return rotateImage(m, true) }, []string{
+			".##",
+			"..#",
+			"...",
+			"...",
+		}},
+		{"rotate counterclockwise", func(m *layeredImage) command { return rotateImage(m, false) }, []string{
+			"...",
+			"...",
+			"#..",
+			"##.",
+		}},
+		{"resize centered", func(m *layeredImage) command { return resizeCanvas(m, 6, 2, anchorCenter) }, []string{
+			".##...",
+			".#....",
+		}},
+		{"resize bottom right", func(m *layeredImage) command { return resizeCanvas(m, 3, 4, anchorBottomRight) }, []string{
+			"...",
+			"#..",
+			"...",
+			"...",
+		}},
+		{"crop", func(m *layeredImage) command { return cropImage(m, image.Rect(1, 0, 3, 2)) }, []string{
+			"#.",
+			"..",
+		}},
+		{"trim", trimImage, []string{
+			"##",
+			"#.",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, _ := createImage("3,4")
+			m := newLayeredImage(i)
+			m.Set(image.Pt(0, 0), color.White)
+			m.Set(image.Pt(1, 0), color.White)
+			m.Set(image.Pt(0, 1), color.White)
+			before := pixelString(m)
+			cmd := tt.transform(m)
+			if cmd == nil {
+				t.Fatal("Expected the image to change")
+			}
+			if got, want := pixelString(m), strings.Join(tt.want, "\n"); got != want {
+				t.Errorf("transform got:\n%s\nwant:\n%s", got, want)
+			}
+			cmd.undo(m)
+			if got := pixelString(m); got != before || m.Bounds() != image.Rect(0, 0, 4, 3) {
+				t.Errorf("Expected undo to restore the image, got %v:\n%s", m.Bounds(), got)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// Filter computes the new color of a single pixel. The whole image is passed
+// so filters can look at neighbouring pixels.
+type Filter interface {
+	Name() string
+	Apply(m image.Image, x, y int) color.Color
+}
+
+// adjustableFilter is a filter with a single numeric parameter.
+type adjustableFilter interface {
+	Filter
+	Adjust(dir direction)
+	SetParam(v float64)
+	Param() string
+}
+
+// filterRegistry holds the constructors of all available filters in menu
+// order. Constructors are used so every use starts with default parameters.
+var filterRegistry = make([]func() Filter, 0)
+
+func registerFilter(newFilter func() Filter) {
+	filterRegistry = append(filterRegistry, newFilter)
+}
+
+func init() {
+	registerFilter(func() Filter { return grayscaleFilter{} })
+	registerFilter(func() Filter { return invertFilter{} })
+	registerFilter(func() Filter { return &posterizeFilter{filterParam{4, 2, 16, 1}} })
+	registerFilter(func() Filter { return &brightnessFilter{filterParam{0.2, -1, 1, 0.1}} })
+	registerFilter(func() Filter { return &contrastFilter{filterParam{0.2, -0.9, 0.9, 0.1}} })
+	registerFilter(func() Filter { return &hueShiftFilter{filterParam{30, -180, 180, 15}} })
+	registerFilter(func() Filter { return outlineFilter{color.Black} })
+}
+
+// getFilters returns new instances of all registered filters.
+func getFilters() []Filter {
+	result := make([]Filter, len(filterRegistry))
+	for i, newFilter := range filterRegistry {
+		result[i] = newFilter()
+	}
+	return result
+}
+
+// findFilter returns a new instance of the filter with the given name.
+func findFilter(name string) (Filter, bool) {
+	for _, f := range getFilters() {
+		if f.Name() == name {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// applyFilter returns the filtered colors of all pixels within r without
+// changing the image.
+func applyFilter(m image.Image, f Filter, r image.Rectangle) layer {
+	r = r.Intersect(m.Bounds())
+	result := make(layer, r.Dx()*r.Dy())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			result[image.Pt(x, y)] = f.Apply(m, x, y)
+		}
+	}
+	return result
+}
+
+// filterParam implements the parameter handling of adjustableFilter.
+type filterParam struct {
+	value float64
+	min   float64
+	max   float64
+	step  float64
+}
+
+func (fp *filterParam) Adjust(dir direction) {
+	if dir == dirIncrease {
+		fp.SetParam(fp.value + fp.step)
+	} else {
+		fp.SetParam(fp.value - fp.step)
+	}
+}
+
+func (fp *filterParam) SetParam(v float64) {
+	fp.value = math.Max(fp.min, math.Min(fp.max, v))
+}
+
+func (fp *filterParam) Param() string {
+	return strconv.FormatFloat(fp.value, 'f', -1, 64)
+}
+
+type grayscaleFilter struct{}
+
+func (grayscaleFilter) Name() string {
+	return "grayscale"
+}
+
+func (grayscaleFilter) Apply(m image.Image, x, y int) color.Color {
+	c := toNRGBA(m.At(x, y))
+	l := clampChannel(0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B))
+	return color.NRGBA{l, l, l, c.A}
+}
+
+type invertFilter struct{}
+
+func (invertFilter) Name() string {
+	return "invert"
+}
+
+func (invertFilter) Apply(m image.Image, x, y int) color.Color {
+	c := toNRGBA(m.At(x, y))
+	return color.NRGBA{255 - c.R, 255 - c.G, 255 - c.B, c.A}
+}
+
+// posterizeFilter reduces every channel to value levels.
+type posterizeFilter struct {
+	filterParam
+}
+
+func (*posterizeFilter) Name() string {
+	return "posterize"
+}
+
+func (f *posterizeFilter) Apply(m image.Image, x, y int) color.Color {
+	c := toNRGBA(m.At(x, y))
+	levels := math.Round(f.value) - 1
+	posterize := func(v uint8) uint8 {
+		return clampChannel(math.Round(float64(v)/255*levels) / levels * 255)
+	}
+	return color.NRGBA{posterize(c.R), posterize(c.G), posterize(c.B), c.A}
+}
+
+// brightnessFilter adds value (-1 to 1) to every channel.
+type brightnessFilter struct {
+	filterParam
+}
+
+func (*brightnessFilter) Name() string {
+	return "brightness"
+}
+
+func (f *brightnessFilter) Apply(m image.Image, x, y int) color.Color {
+	c := toNRGBA(m.At(x, y))
+	add := f.value * 255
+	return color.NRGBA{
+		clampChannel(float64(c.R) + add),
+		clampChannel(float64(c.G) + add),
+		clampChannel(float64(c.B) + add),
+		c.A,
+	}
+}
+
+// contrastFilter increases (value > 0) or decreases (value < 0) the contrast
+// around the middle gray.
+type contrastFilter struct {
+	filterParam
+}
+
+func (*contrastFilter) Name() string {
+	return "contrast"
+}
+
+func (f *contrastFilter) Apply(m image.Image, x, y int) color.Color {
+	c := toNRGBA(m.At(x, y))
+	factor := (1 + f.value) / (1 - f.value)
+	contrast := func(v uint8) uint8 {
+		return clampChannel((float64(v)-128)*factor + 128)
+	}
+	return color.NRGBA{contrast(c.R), contrast(c.G), contrast(c.B), c.A}
+}
+
+// hueShiftFilter rotates the hue by value degrees.
+type hueShiftFilter struct {
+	filterParam
+}
+
+func (*hueShiftFilter) Name() string {
+	return "hue"
+}
+
+func (f *hueShiftFilter) Apply(m image.Image, x, y int) color.Color {
+	c := toNRGBA(m.At(x, y))
+	if c.A == 0 {
+		return c
+	}
+	cl, _ := colorful.MakeColor(color.NRGBA{c.R, c.G, c.B, 255})
+	h, s, v := cl.Hsv()
+	r, g, b := colorful.Hsv(math.Mod(h+f.value+360, 360), s, v).Clamped().RGB255()
+	return color.NRGBA{r, g, b, c.A}
+}
+
+// outlineFilter draws a 1px outline around all non transparent pixels.
+type outlineFilter struct {
+	color color.Color
+}
+
+func (outlineFilter) Name() string {
+	return "outline"
+}
+
+func (f outlineFilter) Apply(m image.Image, x, y int) color.Color {
+	c := m.At(x, y)
+	if _, _, _, a := c.RGBA(); a != 0 {
+		return c
+	}
+	b := m.Bounds()
+	for _, pt := range []image.Point{
+		image.Pt(x-1, y),
+		image.Pt(x+1, y),
+		image.Pt(x, y-1),
+		image.Pt(x, y+1),
+	} {
+		if !pt.In(b) {
+			continue
+		}
+		if _, _, _, a := m.At(pt.X, pt.Y).RGBA(); a != 0 {
+			return f.color
+		}
+	}
+	return c
+}
+
+func toNRGBA(c color.Color) color.NRGBA {
+	return color.NRGBAModel.Convert(c).(color.NRGBA)
+}
+
+func clampChannel(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Round(v))))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_Filter_Apply(t *testing.T) {
+	newFilter := func(name string, param float64) Filter {
+		f, ok := findFilter(name)
+		if !ok {
+			t.Fatalf("filter %s is not registered", name)
+		}
+		if af, ok := f.(adjustableFilter); ok {
+			af.SetParam(param)
+		}
+		return f
+	}
+	tests := []struct {
+		name   string
+		filter Filter
+		color  color.Color
+		want   color.Color
+	}{
+		{
+			"grayscale",
+			newFilter("grayscale", 0),
+			color.NRGBA{255, 0, 0, 255},
+			color.NRGBA{76, 76, 76, 255},
+		},
+		{
+			"invert keeps alpha",
+			newFilter("invert", 0),
+			color.NRGBA{255, 0, 100, 128},
+			color.NRGBA{0, 255, 155, 128},
+		},
+		{
+			"posterize to 2 levels",
+			newFilter("posterize", 2),
+			color.NRGBA{200, 50, 128, 255},
+			color.NRGBA{255, 0, 255, 255},
+		},
+		{
+			"brightness",
+			newFilter("brightness", 0.2),
+			color.NRGBA{100, 250, 0, 255},
+			color.NRGBA{151, 255, 51, 255},
+		},
+		{
+			"contrast",
+			newFilter("contrast", 0.5),
+			color.NRGBA{128, 138, 118, 255},
+			color.NRGBA{128, 158, 98, 255},
+		},
+		{
+			"hue shift",
+			newFilter("hue", 120),
+			color.NRGBA{255, 0, 0, 255},
+			color.NRGBA{0, 255, 0, 255},
+		},
+		{
+			"outline skips opaque pixels",
+			newFilter("outline", 0),
+			color.NRGBA{255, 0, 0, 255},
+			color.NRGBA{255, 0, 0, 255},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+			m.Set(0, 0, tt.color)
+			if got := tt.filter.Apply(m, 0, 0); !sameColor(got, tt.want) {
+				t.Errorf("%s.Apply() = %v, want %v", tt.filter.Name(), got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applyFilter_outline(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 3, 3))
+	m.Set(1, 1, color.White)
+	f, _ := findFilter("outline")
+	result := applyFilter(m, f, m.Bounds())
+	if len(result) != 9 {
+		t.Fatalf("Expected 9 filtered pixels, got %d", len(result))
+	}
+	for _, pt := range []image.Point{image.Pt(1, 0), image.Pt(0, 1), image.Pt(2, 1), image.Pt(1, 2)} {
+		if !sameColor(result[pt], color.Black) {
+			t.Errorf("Expected outline at %s, got %v", pt, result[pt])
+		}
+	}
+	if _, _, _, a := result[image.Pt(0, 0)].RGBA(); a != 0 {
+		t.Errorf("Expected corner to stay transparent, got %v", result[image.Pt(0, 0)])
+	}
+	if m.At(1, 0) != (color.NRGBA{}) {
+		t.Errorf("Expected source image to stay unchanged")
+	}
+}
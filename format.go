@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// formatOptions are the encoder settings chosen on the command line.
+type formatOptions struct {
+	// quality of JPEG images from 1 to 100
+	quality int
+	// plain writes Netpbm images with ASCII samples
+	plain bool
+}
+
+var defaultFormatOptions = formatOptions{quality: jpeg.DefaultQuality}
+
+// imageFormat reads and writes the files of a single format.
+type imageFormat struct {
+	name       string
+	extensions []string
+	// magic are the possible starts of the files, they are used to detect
+	// the format of the data
+	magic  []string
+	decode func(r io.Reader) (image.Image, error)
+	encode func(w io.Writer, m image.Image, opts formatOptions) error
+}
+
+// imageFormats are the formats for loading and saving, the first one is used
+// for files without an extension. Formats without magic are detected by the
+// decoders registered with the image package.
+var imageFormats = []*imageFormat{
+	{"png", []string{".png"}, []string{"\x89PNG\r\n\x1a\n"}, png.Decode, encodePNG},
+	{"gif", []string{".gif"}, []string{"GIF87a", "GIF89a"}, decodeGIFImage, encodeGIFImage},
+	{"jpeg", []string{".jpg", ".jpeg"}, []string{"\xff\xd8\xff"}, jpeg.Decode, encodeJPEG},
+	{"cpxl", []string{projectExtension}, []string{"PK\x03\x04"}, decodeProjectImage, encodeProjectImage},
+	{"pbm", []string{".pbm"}, nil, decodeNetpbm, encodePBM},
+	{"pgm", []string{".pgm"}, nil, decodeNetpbm, encodePGM},
+	{"ppm", []string{".ppm", ".pnm"}, nil, decodeNetpbm, encodePPM},
+	{"pam", []string{".pam"}, nil, decodeNetpbm, encodePAM},
+}
+
+// formatNames returns the names of the supported formats for error messages.
+func formatNames() string {
+	names := make([]string, len(imageFormats))
+	for i, f := range imageFormats {
+		names[i] = f.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// findFormat returns the format with the name or extension.
+func findFormat(name string) (*imageFormat, error) {
+	ext := "." + strings.TrimPrefix(strings.ToLower(name), ".")
+	for _, f := range imageFormats {
+		if ext == "."+f.name {
+			return f, nil
+		}
+		for _, e := range f.extensions {
+			if ext == e {
+				return f, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unsupported image format %s, supported formats are %s", name, formatNames())
+}
+
+// formatForFile returns the format of the file from its extension unless
+// the format is given explicitly. Files without an extension and stdout use
+// the first format.
+func formatForFile(fileName, format string) (*imageFormat, error) {
+	if format != "" {
+		return findFormat(format)
+	}
+	ext := filepath.Ext(fileName)
+	if fileName == stdio || ext == "" {
+		return imageFormats[0], nil
+	}
+	return findFormat(ext)
+}
+
+// detectFormat returns the format of the buffered data or nil if it is not
+// known.
+func detectFormat(r *bufio.Reader) *imageFormat {
+	for _, f := range imageFormats {
+		for _, magic := range f.magic {
+			if data, err := r.Peek(len(magic)); err == nil && string(data) == magic {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// decodeImage reads an image in the format detected from the data, the
+// format is only used for data which is not recognized. Other data is passed
+// to the decoders registered with the image package.
+func decodeImage(r io.Reader, format string) (image.Image, error) {
+	br := bufio.NewReader(r)
+	f := detectFormat(br)
+	if f == nil && format != "" {
+		var err error
+		if f, err = findFormat(format); err != nil {
+			return nil, err
+		}
+	}
+	if f == nil {
+		m, _, err := image.Decode(br)
+		if errors.Is(err, image.ErrFormat) {
+			return nil, fmt.Errorf("unsupported image format, supported formats are %s", formatNames())
+		}
+		return m, err
+	}
+	m, err := f.decode(br)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s image: %w", f.name, err)
+	}
+	return m, nil
+}
+
+// encodePNG writes the current frame, indexed images keep their palette.
+func encodePNG(w io.Writer, m image.Image, opts formatOptions) error {
+	if li, ok := m.(*layeredImage); ok {
+		m = li.export()
+	}
+	return png.Encode(w, m)
+}
+
+func decodeGIFImage(r io.Reader) (image.Image, error) {
+	return decodeGIF(r)
+}
+
+func encodeGIFImage(w io.Writer, m image.Image, opts formatOptions) error {
+	return encodeGIF(w, m)
+}
+
+// encodeJPEG writes the current frame on a white background, JPEG has no
+// transparency.
+func encodeJPEG(w io.Writer, m image.Image, opts formatOptions) error {
+	return jpeg.Encode(w, onWhite(m), &jpeg.Options{Quality: opts.quality})
+}
+
+// onWhite draws the image on a white background for formats without alpha.
+func onWhite(m image.Image) *image.RGBA {
+	b := m.Bounds()
+	result := image.NewRGBA(b)
+	draw.Draw(result, b, image.White, image.Point{}, draw.Src)
+	draw.Draw(result, b, m, b.Min, draw.Over)
+	return result
+}
+
+// decodeProjectImage reads the image of a project, the editor state is only
+// restored from project files opened by name.
+func decodeProjectImage(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	p, err := readProject(zr)
+	if err != nil {
+		return nil, err
+	}
+	return p.m, nil
+}
+
+// encodeProjectImage writes the image as a project without editor state.
+func encodeProjectImage(w io.Writer, m image.Image, opts formatOptions) error {
+	li, ok := m.(*layeredImage)
+	if !ok {
+		li = newLayeredImage(m)
+	}
+	return writeProject(w, &project{m: li, penColor: color.White, history: newHistory()})
+}
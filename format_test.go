@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func Test_formatForFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		format   string
+		want     string
+		wantErr  string
+	}{
+		{"png", "sprite.png", "", "png", ""},
+		{"upper case", "SPRITE.GIF", "", "gif", ""},
+		{"jpg", "photo.jpg", "", "jpeg", ""},
+		{"project", "work.cpxl", "", "cpxl", ""},
+		{"stdout", stdio, "", "png", ""},
+		{"no extension", "sprite", "", "png", ""},
+		{"override", "sprite.png", "gif", "gif", ""},
+		{"override by extension", stdio, ".jpg", "jpeg", ""},
+		{"unsupported", "sprite.bmp", "", "", "unsupported image format .bmp"},
+		{"unsupported override", "sprite.png", "webp", "", "unsupported image format webp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := formatForFile(tt.fileName, tt.format)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("formatForFile() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || f.name != tt.want {
+				t.Errorf("formatForFile() = %v, %v, want %s", f, err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_imageFormats_roundtrip(t *testing.T) {
+	i, _ := createImage("2,3")
+	m := newLayeredImage(i)
+	// JPEG subsamples the colors and Netpbm has gray formats, an image of a
+	// single black color survives all of them
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			m.Set(image.Pt(x, y), color.Black)
+		}
+	}
+	for _, f := range imageFormats {
+		t.Run(f.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := f.encode(&buf, m, defaultFormatOptions); err != nil {
+				t.Fatal(err)
+			}
+			got, err := decodeImage(&buf, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Bounds() != m.Bounds() {
+				t.Errorf("Expected bounds %v, got %v", m.Bounds(), got.Bounds())
+			}
+			if r, g, b, a := got.At(1, 1).RGBA(); r > 0x1000 || g > 0x1000 || b > 0x1000 || a != 0xffff {
+				t.Errorf("Expected a black pixel, got %v", got.At(1, 1))
+			}
+		})
+	}
+}
+
+func Test_decodeImage_errors(t *testing.T) {
+	if _, err := decodeImage(strings.NewReader("BM not supported"), ""); err == nil || !strings.Contains(err.Error(), "unsupported image format") {
+		t.Errorf("decodeImage() error = %v, want unsupported image format", err)
+	}
+	if _, err := decodeImage(strings.NewReader("not a gif"), "gif"); err == nil || !strings.Contains(err.Error(), "invalid gif image") {
+		t.Errorf("decodeImage() error = %v, want invalid gif image", err)
+	}
+}
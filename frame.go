@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+const (
+	defaultFrameDuration = 100 * time.Millisecond
+	// frameDurationStep matches the resolution of GIF delays.
+	frameDurationStep = 10 * time.Millisecond
+	onionOpacity      = 0.3
+	// timelineLength is the number of frames shown in the timeline strip.
+	timelineLength = 10
+)
+
+// frame is a single image of an animation. The layers of the current frame
+// are kept in the layeredImage instead.
+type frame struct {
+	layers   []*imageLayer
+	current  int
+	duration time.Duration
+}
+
+func (f *frame) snapshot() *frame {
+	return &frame{snapshotLayers(f.layers), f.current, f.duration}
+}
+
+// playbackTick is posted to the event loop when the next frame of the
+// playback is due.
+type playbackTick struct {
+	generation int
+}
+
+// frameCount returns the number of frames of the animation.
+func (li *layeredImage) frameCount() int {
+	return len(li.frames)
+}
+
+// duration returns how long the current frame is shown.
+func (li *layeredImage) duration() time.Duration {
+	return li.frames[li.frame].duration
+}
+
+// selectFrame makes frame i the current frame.
+func (li *layeredImage) selectFrame(i int) {
+	if i == li.frame || i < 0 || i >= len(li.frames) {
+		return
+	}
+	li.frames[li.frame].layers, li.frames[li.frame].current = li.layers, li.current
+	li.frame = i
+	li.layers, li.current = li.frames[i].layers, li.frames[i].current
+	li.frames[i].layers = nil
+}
+
+// allLayers returns the layers of all frames.
+func (li *layeredImage) allLayers() []*imageLayer {
+	result := append([]*imageLayer{}, li.layers...)
+	for i, f := range li.frames {
+		if i != li.frame {
+			result = append(result, f.layers...)
+		}
+	}
+	return result
+}
+
+// frameAt returns the composited color of the pixel in frame i.
+func (li *layeredImage) frameAt(i int, p image.Point) color.Color {
+	if i == li.frame {
+		return li.At(p.X, p.Y)
+	}
+	return li.composite(p, li.frames[i].layers, -1, nil)
+}
+
+// addFrame inserts a frame after the current one and selects it. The new
+// frame has the same layers, either empty or with a copy of the pixels.
+func (li *layeredImage) addFrame(duplicate bool) {
+	layers := make([]*imageLayer, len(li.layers))
+	for i, l := range li.layers {
+		layers[i] = l.clone()
+		if !duplicate {
+			layers[i].pixels = image.NewNRGBA(li.bounds)
+		}
+	}
+	f := &frame{layers, li.current, li.duration()}
+	li.frames[li.frame].layers, li.frames[li.frame].current = li.layers, li.current
+	li.frames = append(li.frames[:li.frame+1], append([]*frame{f}, li.frames[li.frame+1:]...)...)
+	li.frame++
+	li.layers, li.current = f.layers, f.current
+	f.layers = nil
+}
+
+// deleteFrame removes the current frame unless it is the last one.
+func (li *layeredImage) deleteFrame() bool {
+	if len(li.frames) < 2 {
+		return false
+	}
+	next := max(0, li.frame-1)
+	li.frames = append(li.frames[:li.frame], li.frames[li.frame+1:]...)
+	li.frame = next
+	li.layers, li.current = li.frames[next].layers, li.frames[next].current
+	li.frames[next].layers = nil
+	return true
+}
+
+// moveFrame moves the current frame back or forward in the animation.
+func (li *layeredImage) moveFrame(dir direction) bool {
+	target := li.frame - 1
+	if dir == dirIncrease {
+		target = li.frame + 1
+	}
+	if target < 0 || target >= len(li.frames) {
+		return false
+	}
+	li.frames[li.frame], li.frames[target] = li.frames[target], li.frames[li.frame]
+	li.frame = target
+	return true
+}
+
+// setDuration changes how long the current frame is shown.
+func (li *layeredImage) setDuration(d time.Duration) bool {
+	d = max64(frameDurationStep, d)
+	if d == li.duration() {
+		return false
+	}
+	li.frames[li.frame].duration = d
+	return true
+}
+
+func max64(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// showFrame switches to frame i, pending strokes and shapes stay on the
+// previous frame.
+func (c *CmdPxl) showFrame(i int) {
+	c.penUp()
+	c.cancelShape()
+	c.m.selectFrame(mod(i, c.m.frameCount()))
+}
+
+// onionColor blends the previous and next frame under the pixel of the
+// current frame.
+func (c *CmdPxl) onionColor(p image.Point, cl color.Color) color.Color {
+	if !c.onionSkin || c.m.frameCount() < 2 {
+		return cl
+	}
+	result := rgba{}
+	for _, i := range []int{c.m.frame - 1, c.m.frame + 1} {
+		if i >= 0 && i < c.m.frameCount() {
+			result = result.over(newRGBA(c.m.frameAt(i, p)), onionOpacity, blendNormal)
+		}
+	}
+	return result.over(newRGBA(cl), 1, blendNormal).color()
+}
+
+// play starts or stops the playback of the animation.
+func (c *CmdPxl) play() {
+	if c.currentState == statePlayback {
+		c.currentState = stateDrawing
+		c.playback++
+		return
+	}
+	c.penUp()
+	c.cancelShape()
+	c.marking = false
+	c.currentState = statePlayback
+	c.playback++
+	c.scheduleFrame()
+}
+
+// scheduleFrame posts a tick when the current frame was shown for its
+// duration. Ticks of stopped playbacks are ignored.
+func (c *CmdPxl) scheduleFrame() {
+	tick := playbackTick{c.playback}
+	time.AfterFunc(c.m.duration(), func() {
+		_ = c.s.PostEvent(tcell.NewEventInterrupt(tick))
+	})
+}
+
+// nextFrame advances the playback, it loops forever.
+func (c *CmdPxl) nextFrame(tick playbackTick) {
+	if c.currentState != statePlayback || tick.generation != c.playback {
+		return
+	}
+	c.m.selectFrame((c.m.frame + 1) % c.m.frameCount())
+	c.scheduleFrame()
+}
+
+func (c *CmdPxl) handlePlaybackKey(ev *tcell.EventKey) {
+	if ev.Key() == tcell.KeyEscape || ev.Rune() == 'R' || ev.Rune() == 'x' || ev.Rune() == ' ' {
+		c.play()
+	}
+}
+
+func (c *CmdPxl) handleFramesKey(ev *tcell.EventKey) {
+	switch {
+	case ev.Key() == tcell.KeyEscape || ev.Key() == tcell.KeyEnter || ev.Rune() == 'F' || ev.Rune() == 'x':
+		c.currentState = stateDrawing
+		c.s.Clear()
+	case ev.Key() == tcell.KeyUp || ev.Rune() == 'w':
+		c.m.selectFrame(c.m.frame - 1)
+	case ev.Key() == tcell.KeyDown || ev.Rune() == 's':
+		c.m.selectFrame(c.m.frame + 1)
+	case ev.Rune() == 'W':
+		c.changeLayers(func(m *layeredImage) bool { return m.moveFrame(dirDecrease) })
+	case ev.Rune() == 'S':
+		c.changeLayers(func(m *layeredImage) bool { return m.moveFrame(dirIncrease) })
+	case ev.Rune() == 'n' || ev.Rune() == 'c':
+		duplicate := ev.Rune() == 'c'
+		c.changeLayers(func(m *layeredImage) bool {
+			m.addFrame(duplicate)
+			return true
+		})
+	case ev.Rune() == 'X':
+		c.changeLayers(func(m *layeredImage) bool { return m.deleteFrame() })
+		c.s.Clear()
+	case ev.Key() == tcell.KeyLeft || ev.Rune() == 'a':
+		c.changeDuration(-frameDurationStep)
+	case ev.Key() == tcell.KeyRight || ev.Rune() == 'd':
+		c.changeDuration(frameDurationStep)
+	case ev.Rune() == 'A':
+		c.changeDuration(-10 * frameDurationStep)
+	case ev.Rune() == 'D':
+		c.changeDuration(10 * frameDurationStep)
+	case ev.Rune() == 'o':
+		c.onionSkin = !c.onionSkin
+	case ev.Rune() == 'z':
+		c.history.undo(c.m)
+		c.s.Clear()
+	case ev.Rune() == 'y':
+		c.history.redo(c.m)
+		c.s.Clear()
+	}
+}
+
+func (c *CmdPxl) changeDuration(delta time.Duration) {
+	c.changeLayers(func(m *layeredImage) bool { return m.setDuration(m.duration() + delta) })
+}
+
+// drawFramesPanel lists the frames with their durations.
+func (c *CmdPxl) drawFramesPanel() *drawBox {
+	items := make([]string, c.m.frameCount())
+	for i, f := range c.m.frames {
+		items[i] = fmt.Sprintf("frame %-3d %5dms", i+1, f.duration.Milliseconds())
+	}
+	mn := newMenu("Frames", items)
+	mn.selected = c.m.frame
+	onion := "off"
+	if c.onionSkin {
+		onion = "on"
+	}
+	return mn.draw(c.s, 0, 0, c.interfaceStyle,
+		"[w/s] select [W/S] move",
+		"[n] new [c] copy [X] delete",
+		"[a/d] duration [A/D] by 100ms",
+		"[o] onion skin: "+onion,
+		"[z/y] undo/redo [esc] close",
+	)
+}
+
+// timeline returns the strip of frames around the current frame.
+func (c *CmdPxl) timeline() string {
+	first := min(max(0, c.m.frame-timelineLength/2), max(0, c.m.frameCount()-timelineLength))
+	var sb strings.Builder
+	for i := first; i < min(first+timelineLength, c.m.frameCount()); i++ {
+		if i == c.m.frame {
+			sb.WriteRune('●')
+		} else {
+			sb.WriteRune('○')
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+// framePixels returns the color of p in every frame.
+func framePixels(m *layeredImage, p image.Point) []color.Color {
+	result := make([]color.Color, m.frameCount())
+	for i := range result {
+		result[i] = m.frameAt(i, p)
+	}
+	return result
+}
+
+func Test_layeredImage_frames(t *testing.T) {
+	i, _ := createImage("2,2")
+	m := newLayeredImage(i)
+	red := color.NRGBA{255, 0, 0, 255}
+	m.Set(image.Pt(0, 0), red)
+
+	m.addFrame(true)
+	m.addFrame(false)
+	if m.frameCount() != 3 || m.frame != 2 {
+		t.Fatalf("Expected the third of 3 frames to be selected, got %d of %d", m.frame, m.frameCount())
+	}
+	got := framePixels(m, image.Pt(0, 0))
+	if !sameColor(got[0], red) || !sameColor(got[1], red) || !sameColor(got[2], color.Transparent) {
+		t.Errorf("Expected a copied and an empty frame, got %v", got)
+	}
+
+	m.moveFrame(dirDecrease)
+	m.moveFrame(dirDecrease)
+	if m.frame != 0 || !sameColor(m.At(0, 0), color.Transparent) || m.moveFrame(dirDecrease) {
+		t.Errorf("Expected the empty frame to move to the front, got frame %d", m.frame)
+	}
+
+	m.selectFrame(1)
+	m.setDuration(0)
+	if m.duration() != frameDurationStep {
+		t.Errorf("Expected the shortest duration to be %s, got %s", frameDurationStep, m.duration())
+	}
+	if !m.deleteFrame() || m.frameCount() != 2 || m.frame != 0 {
+		t.Errorf("Expected the previous frame to be selected after delete, got %d of %d", m.frame, m.frameCount())
+	}
+	if len(m.allLayers()) != 2 {
+		t.Errorf("Expected 2 layers in all frames, got %d", len(m.allLayers()))
+	}
+}
+
+func Test_pixelCommand_frames(t *testing.T) {
+	i, _ := createImage("2,2")
+	m := newLayeredImage(i)
+	m.addFrame(false)
+	cmd := paintPixel(m, image.Pt(1, 1), color.White)
+	m.selectFrame(0)
+	cmd.undo(m)
+	if m.frame != 1 || !sameColor(m.At(1, 1), color.Transparent) {
+		t.Errorf("Expected undo to switch to the painted frame, got frame %d", m.frame)
+	}
+}
+
+func Test_writeProject_frames(t *testing.T) {
+	i, _ := createImage("2,2")
+	c := NewCmdPxl("test.cpxl", i, nil, nil)
+	c.m.Set(image.Pt(0, 0), color.White)
+	c.changeLayers(func(m *layeredImage) bool {
+		m.addFrame(false)
+		m.Set(image.Pt(1, 1), color.White)
+		return m.setDuration(250 * time.Millisecond)
+	})
+	c.m.selectFrame(0)
+
+	b := new(bytes.Buffer)
+	if err := writeProject(b, c.project()); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := readProject(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.m.frameCount() != 2 || p.m.frame != 0 {
+		t.Fatalf("Expected 2 frames with the first one selected, got %d", p.m.frameCount())
+	}
+	if got := framePixels(p.m, image.Pt(1, 1)); !sameColor(got[0], color.Transparent) || !sameColor(got[1], color.White) {
+		t.Errorf("Expected the pixels of the frames to be restored, got %v", got)
+	}
+	if p.m.frames[1].duration != 250*time.Millisecond {
+		t.Errorf("Expected a duration of 250ms, got %s", p.m.frames[1].duration)
+	}
+	p.history.undo(p.m)
+	if p.m.frameCount() != 1 {
+		t.Errorf("Expected undo to remove the frame, got %d frames", p.m.frameCount())
+	}
+}
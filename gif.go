@@ -0,0 +1,130 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"time"
+)
+
+// gifDelay is the unit of the frame delays of GIF files.
+const gifDelay = 10 * time.Millisecond
+
+// decodeGIF reads all frames of an animated GIF. The frames of the file
+// only contain the changed part of the image, they are composed according to
+// their disposal methods into complete frames.
+func decodeGIF(r io.Reader) (*layeredImage, error) {
+	g, err := gif.DecodeAll(r)
+	if err != nil {
+		return nil, err
+	}
+	b := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	canvas := image.NewNRGBA(b)
+	frames := make([]*frame, len(g.Image))
+	for i, pm := range g.Image {
+		previous := image.NewNRGBA(b)
+		copy(previous.Pix, canvas.Pix)
+		draw.Draw(canvas, pm.Bounds(), pm, pm.Bounds().Min, draw.Over)
+
+		frames[i] = &frame{layers: newLayeredImage(canvas).layers, duration: defaultFrameDuration}
+		if g.Delay[i] > 0 {
+			frames[i].duration = time.Duration(g.Delay[i]) * gifDelay
+		}
+
+		switch g.Disposal[i] {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, pm.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	result := &layeredImage{
+		layers:    frames[0].layers,
+		bounds:    b,
+		palette:   sharedPalette(g),
+		frames:    frames,
+		loopCount: g.LoopCount,
+	}
+	frames[0].layers = nil
+	return result, nil
+}
+
+// sharedPalette returns the palette if all frames use the same one, the image
+// can then be edited with indexed colors.
+func sharedPalette(g *gif.GIF) color.Palette {
+	pal := g.Image[0].Palette
+	for _, pm := range g.Image[1:] {
+		if len(pm.Palette) != len(pal) {
+			return nil
+		}
+		for i := range pal {
+			if !sameColor(pm.Palette[i], pal[i]) {
+				return nil
+			}
+		}
+	}
+	return pal
+}
+
+// flattenFrame returns the composited frame i moved to the origin.
+func (li *layeredImage) flattenFrame(i int) *image.NRGBA {
+	b := li.bounds
+	result := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			result.Set(x-b.Min.X, y-b.Min.Y, li.frameAt(i, image.Pt(x, y)))
+		}
+	}
+	return result
+}
+
+// toGIF converts all frames to a GIF animation. GIF only has fully
+// transparent or opaque pixels, images with free colors get a quantized
+// palette shared by all frames.
+func (li *layeredImage) toGIF() *gif.GIF {
+	frames := make([]image.Image, li.frameCount())
+	for i := range frames {
+		m := li.flattenFrame(i)
+		for p := 0; p < len(m.Pix); p += 4 {
+			if m.Pix[p+3] < 0x80 {
+				copy(m.Pix[p:p+4], []uint8{0, 0, 0, 0})
+			} else {
+				m.Pix[p+3] = 0xff
+			}
+		}
+		frames[i] = m
+	}
+	pal := li.palette
+	if pal == nil {
+		pal = quantize(frames...)
+	}
+	b := image.Rect(0, 0, li.bounds.Dx(), li.bounds.Dy())
+	g := &gif.GIF{
+		Config:    image.Config{ColorModel: pal, Width: b.Dx(), Height: b.Dy()},
+		LoopCount: li.loopCount,
+	}
+	for i, m := range frames {
+		pm := image.NewPaletted(b, pal)
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < b.Dx(); x++ {
+				pm.SetColorIndex(x, y, uint8(pal.Index(m.At(x, y))))
+			}
+		}
+		g.Image = append(g.Image, pm)
+		g.Delay = append(g.Delay, int((li.frames[i].duration+gifDelay/2)/gifDelay))
+		// every frame is complete, clear the previous one
+		g.Disposal = append(g.Disposal, gif.DisposalBackground)
+	}
+	return g
+}
+
+// encodeGIF writes the image as an animated GIF.
+func encodeGIF(w io.Writer, m image.Image) error {
+	li, ok := m.(*layeredImage)
+	if !ok {
+		li = newLayeredImage(m)
+	}
+	return gif.EncodeAll(w, li.toGIF())
+}
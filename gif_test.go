@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+	"time"
+)
+
+func Test_decodeGIF_disposal(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+	green := color.NRGBA{0, 255, 0, 255}
+	pal := color.Palette{color.NRGBA{}, red, blue, green}
+	frame := func(x0, x1 int, index uint8) *image.Paletted {
+		pm := image.NewPaletted(image.Rect(x0, 0, x1, 1), pal)
+		for i := range pm.Pix {
+			pm.Pix[i] = index
+		}
+		return pm
+	}
+	g := &gif.GIF{
+		Image:     []*image.Paletted{frame(0, 3, 1), frame(1, 2, 2), frame(2, 3, 3), frame(0, 1, 3)},
+		Delay:     []int{0, 5, 5, 20},
+		Disposal:  []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalPrevious, gif.DisposalNone},
+		LoopCount: 3,
+		Config:    image.Config{ColorModel: pal, Width: 3, Height: 1},
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	m, err := decodeImage(&buf, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	li, ok := m.(*layeredImage)
+	if !ok {
+		t.Fatalf("Expected a layered image, got %T", m)
+	}
+	want := [][]color.Color{
+		{red, red, red},
+		{red, blue, red},
+		{red, color.Transparent, green},
+		{green, color.Transparent, red},
+	}
+	if li.frameCount() != len(want) {
+		t.Fatalf("Expected %d frames, got %d", len(want), li.frameCount())
+	}
+	for i, row := range want {
+		for x, c := range row {
+			if got := li.frameAt(i, image.Pt(x, 0)); !sameColor(got, c) {
+				t.Errorf("frame %d pixel %d = %v, want %v", i, x, got, c)
+			}
+		}
+	}
+	if li.frames[0].duration != defaultFrameDuration || li.frames[3].duration != 200*time.Millisecond {
+		t.Errorf("Expected the delays to be read, got %s and %s", li.frames[0].duration, li.frames[3].duration)
+	}
+	if li.loopCount != 3 || len(li.palette) != len(pal) {
+		t.Errorf("Expected loop count 3 and the shared palette, got %d and %v", li.loopCount, li.palette)
+	}
+}
+
+func Test_encodeGIF(t *testing.T) {
+	m := newLayeredImage(textImage(
+		"#.",
+		".#",
+	))
+	m.Set(image.Pt(1, 0), color.NRGBA{255, 0, 0, 100})
+	m.addFrame(false)
+	m.Set(image.Pt(0, 1), color.NRGBA{0, 0, 255, 200})
+	m.setDuration(250 * time.Millisecond)
+	m.loopCount = -1
+
+	var buf bytes.Buffer
+	if err := encodeGIF(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) != 2 || g.Delay[0] != 10 || g.Delay[1] != 25 || g.LoopCount != -1 {
+		t.Errorf("Expected 2 frames with delays 10 and 25 shown once, got %d %v %d", len(g.Image), g.Delay, g.LoopCount)
+	}
+	li, err := decodeGIF(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		frame int
+		p     image.Point
+		want  color.Color
+	}{
+		{0, image.Pt(0, 0), color.Black},
+		// GIF pixels are either transparent or opaque
+		{0, image.Pt(1, 0), color.Transparent},
+		{0, image.Pt(1, 1), color.Black},
+		{1, image.Pt(0, 0), color.Transparent},
+		{1, image.Pt(0, 1), color.NRGBA{0, 0, 255, 255}},
+	} {
+		if got := li.frameAt(tt.frame, tt.p); !sameColor(got, tt.want) {
+			t.Errorf("frame %d pixel %s = %v, want %v", tt.frame, tt.p, got, tt.want)
+		}
+	}
+}
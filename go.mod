@@ -5,6 +5,7 @@ go 1.17
 require (
 	github.com/gdamore/tcell/v2 v2.4.0
 	github.com/lucasb-eyer/go-colorful v1.2.0
+	golang.org/x/term v0.0.0-20201210144234-2321bbc49cbf
 )
 
 require (
@@ -12,6 +13,5 @@ require (
 	github.com/mattn/go-runewidth v0.0.13 // indirect
 	github.com/rivo/uniseg v0.2.0 // indirect
 	golang.org/x/sys v0.0.0-20201119102817-f84b799fce68 // indirect
-	golang.org/x/term v0.0.0-20201210144234-2321bbc49cbf // indirect
 	golang.org/x/text v0.3.0 // indirect
 )
@@ -0,0 +1,707 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// harness runs the editor on a simulation screen. Events are processed the
+// same way as in CmdPxl.Run, one at a time followed by a redraw.
+type harness struct {
+	t     *testing.T
+	c     *CmdPxl
+	s     tcell.SimulationScreen
+	quit  bool
+	saved image.Image
+}
+
+func newHarness(t *testing.T, fileName string, m image.Image, width, height int) *harness {
+	h := &harness{t: t, s: tcell.NewSimulationScreen("UTF-8")}
+	h.c = NewCmdPxl(fileName, m, func(fileName string, m image.Image) error {
+		h.saved = m
+		return nil
+	}, h.s)
+	if err := h.c.init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.s.Fini)
+	h.resize(width, height)
+	return h
+}
+
+func (h *harness) event(ev tcell.Event) *harness {
+	h.t.Helper()
+	if h.quit {
+		h.t.Fatalf("event %T sent after the editor quit", ev)
+	}
+	quit, err := h.c.handleEvent(ev)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	h.quit = quit
+	if !quit {
+		h.c.draw()
+		h.s.Show()
+	}
+	return h
+}
+
+func (h *harness) resize(width, height int) *harness {
+	h.s.SetSize(width, height)
+	return h.event(tcell.NewEventResize(width, height))
+}
+
+// keys sends a key event for every rune of the string.
+func (h *harness) keys(runes string) *harness {
+	for _, r := range runes {
+		h.event(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+	}
+	return h
+}
+
+func (h *harness) key(k tcell.Key) *harness {
+	return h.event(tcell.NewEventKey(k, 0, tcell.ModNone))
+}
+
+func (h *harness) mouse(x, y int, buttons tcell.ButtonMask) *harness {
+	return h.event(tcell.NewEventMouse(x, y, buttons, tcell.ModNone))
+}
+
+// dump returns the text rendered on the screen with trailing spaces removed.
+func (h *harness) dump() string {
+	cells, width, height := h.s.GetContents()
+	var sb strings.Builder
+	for y := 0; y < height; y++ {
+		var line strings.Builder
+		for x := 0; x < width; x++ {
+			cell := cells[y*width+x]
+			if len(cell.Runes) == 0 {
+				line.WriteRune(' ')
+			} else {
+				line.WriteString(string(cell.Runes))
+			}
+		}
+		sb.WriteString(strings.TrimRight(line.String(), " "))
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// assertGolden compares the screen with testdata/name.golden, run the tests
+// with -update to rewrite the file.
+func (h *harness) assertGolden(name string) {
+	h.t.Helper()
+	fileName := filepath.Join("testdata", name+".golden")
+	got := h.dump()
+	if *update {
+		if err := os.WriteFile(fileName, []byte(got), 0644); err != nil {
+			h.t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(fileName)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if got != string(want) {
+		h.t.Errorf("screen does not match %s, got:\n%s\nwant:\n%s", fileName, got, want)
+	}
+}
+
+func (h *harness) assertPixel(x, y int, want color.Color) {
+	h.t.Helper()
+	if got := h.c.m.At(x, y); !sameColor(got, want) {
+		h.t.Errorf("Expected %v at %d,%d, got %v", want, x, y, got)
+	}
+}
+
+// assertCellBackground checks the color displayed at the screen position.
+func (h *harness) assertCellBackground(x, y int, want color.Color) {
+	h.t.Helper()
+	_, _, style, _ := h.s.GetContent(x, y)
+	_, bg, _ := style.Decompose()
+	if bg != tcell.FromImageColor(want) {
+		h.t.Errorf("Expected background %v at %d,%d, got %v", want, x, y, bg)
+	}
+}
+
+func Test_CmdPxl_drawAndUndo(t *testing.T) {
+	i, _ := createImage("4,6")
+	h := newHarness(t, "test.png", i, 80, 24)
+	h.assertGolden("empty")
+
+	h.keys("e").keys("ds").keys("e")
+	h.assertPixel(0, 0, color.White)
+	h.assertPixel(1, 1, color.White)
+	// image box starts at the padding, the canvas at the next cell
+	p := h.c.imageBox.getPoint(2, 1)
+	h.assertCellBackground(p.X, p.Y, color.White)
+
+	h.keys("z")
+	h.assertPixel(1, 1, color.Transparent)
+	h.keys("y")
+	h.assertPixel(1, 1, color.White)
+
+	h.keys("dd").keys("f")
+	h.assertPixel(5, 3, color.White)
+	h.assertGolden("filled")
+
+	h.keys("x")
+	h.assertGolden("quit")
+	h.keys("y")
+	if !h.quit || h.saved == nil {
+		t.Fatalf("Expected the editor to save and quit")
+	}
+	if got := h.saved.At(5, 3); !sameColor(got, color.White) {
+		t.Errorf("Expected saved image to be filled, got %v", got)
+	}
+}
+
+func Test_CmdPxl_quit(t *testing.T) {
+	i, _ := createImage("4,6")
+	h := newHarness(t, stdio, i, 80, 24)
+	h.keys("x")
+	if !h.quit || h.saved != nil {
+		t.Errorf("Expected to quit without saving an unchanged image")
+	}
+
+	h = newHarness(t, stdio, i, 80, 24)
+	h.keys("e").keys("x").keys("d")
+	if !h.quit || h.saved != nil {
+		t.Errorf("Expected to discard the changes")
+	}
+}
+
+func Test_CmdPxl_continuousDraw(t *testing.T) {
+	i, _ := createImage("4,6")
+	h := newHarness(t, "test.png", i, 80, 24)
+
+	// changing the color with the pen down only paints the next pixels
+	h.keys("p").keys("oo").keys("d")
+	dark := h.c.penColor.c
+	h.keys("j").keys("c").keys("p")
+	h.assertPixel(0, 0, color.White)
+	h.assertPixel(1, 0, dark)
+	if !sameColor(h.c.penColor.c, dark) {
+		t.Errorf("Expected to pick the painted %v, got %v", dark, h.c.penColor.c)
+	}
+	h.keys("z")
+	h.assertPixel(0, 0, color.Transparent)
+}
+
+func Test_CmdPxl_continuousDrawHistory(t *testing.T) {
+	i, _ := createImage("4,6")
+	h := newHarness(t, "test.png", i, 80, 24)
+
+	// commands made with the pen down come after the pixels painted before
+	h.keys("p").keys("j").keys("e").keys("p").keys("zz")
+	h.assertPixel(0, 0, color.Transparent)
+	if h.c.history.changed() {
+		t.Errorf("Expected all changes to be undone, got %d history items", len(h.c.history.undoStack))
+	}
+
+	p := h.c.imageBox.getPoint(0, 0)
+	h.keys("p").
+		mouse(p.X+2, p.Y, tcell.Button1).
+		mouse(p.X+2, p.Y, tcell.ButtonNone).
+		keys("p").keys("z")
+	h.assertPixel(0, 0, color.White)
+	h.assertPixel(1, 0, color.Transparent)
+	h.keys("z")
+	h.assertPixel(0, 0, color.Transparent)
+	if h.c.history.changed() {
+		t.Errorf("Expected all changes to be undone, got %d history items", len(h.c.history.undoStack))
+	}
+}
+
+func Test_CmdPxl_mouse(t *testing.T) {
+	i, _ := createImage("4,6")
+	h := newHarness(t, "test.png", i, 80, 24)
+	p := h.c.imageBox.getPoint(0, 0)
+
+	h.mouse(p.X, p.Y, tcell.Button1).
+		mouse(p.X+2, p.Y, tcell.Button1).
+		mouse(p.X+4, p.Y+1, tcell.Button1).
+		mouse(p.X+4, p.Y+1, tcell.ButtonNone)
+	h.assertPixel(0, 0, color.White)
+	h.assertPixel(1, 0, color.White)
+	h.assertPixel(2, 1, color.White)
+	h.keys("z")
+	h.assertPixel(0, 0, color.Transparent)
+
+	// pick the darkest value swatch
+	swatch := h.c.getColorSelectBox().getPoint(2*sectionWidth, 1)
+	h.mouse(swatch.X, swatch.Y, tcell.Button1).mouse(swatch.X, swatch.Y, tcell.ButtonNone)
+	h.keys("e")
+	h.assertPixel(2, 1, color.Black)
+}
+
+func Test_CmdPxl_alpha(t *testing.T) {
+	i, _ := createImage("4,6")
+	h := newHarness(t, "test.png", i, 80, 24)
+	h.keys(",,,,,").keys("e")
+	h.assertPixel(0, 0, color.NRGBA{255, 255, 255, 128})
+	// semi-transparent pixels are blended over the checkerboard
+	p := h.c.imageBox.getPoint(0, 0)
+	_, _, style, _ := h.s.GetContent(p.X, p.Y)
+	_, bg, _ := style.Decompose()
+	if want := tcell.NewRGBColor(0xcc, 0xcc, 0xcc); bg != want {
+		t.Errorf("Expected background %v, got %v", want, bg)
+	}
+
+	h.keys("E").keys("d").keys("e")
+	h.assertPixel(1, 0, color.Transparent)
+	h.keys("E").keys("d").keys("e")
+	h.assertPixel(2, 0, color.NRGBA{255, 255, 255, 128})
+
+	// pick the opaque alpha swatch
+	swatch := h.c.getColorSelectBox().getPoint(3*sectionWidth+10, 1)
+	h.mouse(swatch.X, swatch.Y, tcell.Button1).mouse(swatch.X, swatch.Y, tcell.ButtonNone)
+	h.keys("d").keys("e")
+	h.assertPixel(3, 0, color.White)
+	h.assertGolden("alpha")
+}
+
+func Test_CmdPxl_filterMenu(t *testing.T) {
+	i, _ := createImage("4,6")
+	h := newHarness(t, "test.png", i, 80, 24)
+	h.keys("e").keys("t")
+	h.assertGolden("filters")
+	h.keys("s")
+	h.key(tcell.KeyEnter)
+	h.assertPixel(0, 0, color.Black)
+	h.keys("z")
+	h.assertPixel(0, 0, color.White)
+}
+
+func Test_CmdPxl_halfBlock(t *testing.T) {
+	i, _ := createImage("4,6")
+	h := newHarness(t, "test.png", i, 80, 24)
+	h.keys("ds").keys("e").keys("aw").keys("v")
+	if h.c.getRenderMode().String() != "2x½" {
+		t.Fatalf("Expected half-block mode, got %s", h.c.getRenderMode())
+	}
+	canvas := h.c.imageBox.getCanvas()
+	if canvas.Dx()+1 != 12 || canvas.Dy()+1 != 2 {
+		t.Errorf("Expected 12x2 canvas, got %dx%d", canvas.Dx()+1, canvas.Dy()+1)
+	}
+	// the painted pixel is the bottom half of the cell below the cursor
+	p := h.c.imageBox.getPoint(2, 0)
+	r, _, style, _ := h.s.GetContent(p.X, p.Y)
+	fg, bg, _ := style.Decompose()
+	if r != '▀' || bg != tcell.FromImageColor(color.White) || fg != tcell.FromImageColor(checkerColor(image.Pt(1, 0))) {
+		t.Errorf("Expected half block with checkerboard over white, got %q %v %v", r, fg, bg)
+	}
+	h.assertGolden("half-block")
+
+	h.keys("-")
+	canvas = h.c.imageBox.getCanvas()
+	if canvas.Dx()+1 != 6 || canvas.Dy()+1 != 2 {
+		t.Errorf("Expected 6x2 canvas, got %dx%d", canvas.Dx()+1, canvas.Dy()+1)
+	}
+	h.keys("v")
+	if h.c.getRenderMode().String() != "1x½" {
+		t.Errorf("Expected the smallest zoom to stay half-block, got %s", h.c.getRenderMode())
+	}
+}
+
+func Test_CmdPxl_zoom(t *testing.T) {
+	i, _ := createImage("20,30")
+	h := newHarness(t, "test.png", i, 80, 30)
+	// move the cursor to the middle of the image
+	h.keys(strings.Repeat("d", 15)).keys(strings.Repeat("s", 10))
+	h.keys("++")
+	if h.c.getRenderMode().String() != "6x3" {
+		t.Fatalf("Expected 6x3 zoom, got %s", h.c.getRenderMode())
+	}
+	if size := h.c.viewport().size(); size != image.Pt(13, 5) {
+		t.Errorf("Expected 13x5 pixel view, got %v", size)
+	}
+	if h.c.pan != image.Pt(9, 8) {
+		t.Errorf("Expected the cursor to be centered, got pan %v", h.c.pan)
+	}
+	if h.c.cursor != image.Pt(15, 10) {
+		t.Errorf("Expected the cursor to stay on the same pixel, got %v", h.c.cursor)
+	}
+	h.assertGolden("zoom")
+	h.keys("----")
+	if h.c.getRenderMode().String() != "1x½" || h.c.pan != image.Pt(0, 0) {
+		t.Errorf("Expected smallest zoom without panning, got %s pan %v", h.c.getRenderMode(), h.c.pan)
+	}
+}
+
+func Test_CmdPxl_viewport(t *testing.T) {
+	i, _ := createImage("20,40")
+	h := newHarness(t, "test.png", i, 60, 24)
+	size := h.c.viewport().size()
+	if size.X >= 40 || size.Y >= 20 {
+		t.Fatalf("Expected the image not to fit on the canvas, got %v", size)
+	}
+	// moving past the canvas scrolls the view
+	h.keys(strings.Repeat("d", size.X)).keys(strings.Repeat("s", size.Y))
+	if h.c.cursor != size || h.c.pan != image.Pt(1, 1) {
+		t.Fatalf("Expected the view to follow the cursor, got cursor %v pan %v", h.c.cursor, h.c.pan)
+	}
+	// fill and draw use the image position of the cursor
+	h.keys("e")
+	h.assertPixel(size.X, size.Y, color.White)
+	h.keys("z").keys("f")
+	h.assertPixel(size.X, size.Y, color.White)
+	p := h.c.viewport().imageToScreen(h.c.cursor)
+	h.assertCellBackground(p.X, p.Y, color.White)
+	h.keys("z")
+
+	// panning keeps the cursor on the canvas
+	h.key(tcell.KeyRight).key(tcell.KeyRight)
+	if h.c.pan.X != 3 || h.c.cursor.X != size.X {
+		t.Errorf("Expected pan 3 with the cursor in place, got pan %v cursor %v", h.c.pan, h.c.cursor)
+	}
+	h.key(tcell.KeyLeft).key(tcell.KeyLeft).key(tcell.KeyLeft)
+	if h.c.pan.X != 0 || h.c.cursor.X != size.X-1 {
+		t.Errorf("Expected the cursor to move into the view, got pan %v cursor %v", h.c.pan, h.c.cursor)
+	}
+
+	// picking a color reads the pixel under the cursor
+	h.c.m.Set(h.c.cursor, color.Black)
+	h.keys("c")
+	if !sameColor(h.c.penColor.c, color.Black) {
+		t.Errorf("Expected to pick black, got %v", h.c.penColor.c)
+	}
+
+	// the mouse paints the pixel shown in the cell left of the cursor
+	p = h.c.viewport().imageToScreen(h.c.cursor)
+	p.X -= h.c.getRenderMode().cols
+	h.mouse(p.X, p.Y, tcell.Button1).mouse(p.X, p.Y, tcell.ButtonNone)
+	h.assertPixel(size.X-2, size.Y, color.Black)
+	if h.c.cursor != image.Pt(size.X-2, size.Y) {
+		t.Errorf("Expected the cursor to follow the mouse, got %v", h.c.cursor)
+	}
+}
+
+func Test_CmdPxl_shapes(t *testing.T) {
+	i, _ := createImage("6,8")
+	h := newHarness(t, "test.png", i, 80, 24)
+	h.keys("2").keys("e").keys("ddds")
+	// the preview is shown without changing the image
+	h.assertPixel(3, 1, color.Transparent)
+	p := h.c.viewport().imageToScreen(image.Pt(3, 1))
+	h.assertCellBackground(p.X+1, p.Y, color.White)
+	h.assertGolden("shape")
+	h.keys("e")
+	h.assertPixel(0, 0, color.White)
+	h.assertPixel(3, 1, color.White)
+	if len(h.c.history.undoStack) != 1 {
+		t.Fatalf("Expected the line to be a single history item, got %d", len(h.c.history.undoStack))
+	}
+	h.keys("z")
+	h.assertPixel(3, 1, color.Transparent)
+
+	// alt constrains the rectangle to a square
+	h.keys("3").keys("e")
+	h.event(tcell.NewEventKey(tcell.KeyRune, 's', tcell.ModAlt))
+	h.event(tcell.NewEventKey(tcell.KeyRune, 's', tcell.ModAlt))
+	h.keys("e")
+	h.assertPixel(5, 3, color.White)
+	h.assertPixel(4, 2, color.Transparent)
+	h.assertPixel(5, 4, color.Transparent)
+
+	// escape cancels the shape instead of quitting
+	h.keys("5").keys("e").key(tcell.KeyEscape)
+	if h.c.shape != nil || h.c.preview != nil {
+		t.Errorf("Expected the shape to be cancelled")
+	}
+
+	// the mouse draws shapes by dragging
+	h.keys("4")
+	start := h.c.viewport().imageToScreen(image.Pt(0, 4))
+	end := h.c.viewport().imageToScreen(image.Pt(1, 5))
+	h.mouse(start.X, start.Y, tcell.Button1).mouse(end.X, end.Y, tcell.Button1).mouse(end.X, end.Y, tcell.ButtonNone)
+	h.assertPixel(1, 5, color.White)
+	h.assertPixel(2, 5, color.Transparent)
+}
+
+func Test_CmdPxl_selection(t *testing.T) {
+	i, _ := createImage("6,8")
+	h := newHarness(t, "test.png", i, 80, 24)
+	// select 3x2 pixels from 1,1
+	h.keys("ds").keys("m").keys("dds").keys("m")
+	if h.c.selection != image.Rect(1, 1, 4, 3) {
+		t.Fatalf("Expected selection 1,1-4,3, got %v", h.c.selection)
+	}
+	h.assertGolden("selection")
+	phase := h.c.antsPhase
+	if !h.c.antsScheduled {
+		t.Errorf("Expected a tick to be scheduled while a selection is shown")
+	}
+	h.event(tcell.NewEventInterrupt(antsTick{}))
+	if h.c.antsPhase != phase+1 || !h.c.antsScheduled {
+		t.Errorf("Expected the outline to march on ticks")
+	}
+
+	// fill does not leave the selection
+	h.keys("f")
+	h.assertPixel(1, 1, color.White)
+	h.assertPixel(3, 2, color.White)
+	h.assertPixel(4, 2, color.Transparent)
+	h.assertPixel(0, 0, color.Transparent)
+
+	// paste a copy and move it before anchoring
+	h.keys("C").keys("V").keys("dd")
+	if h.c.currentState != stateFloating {
+		t.Fatalf("Expected floating paste")
+	}
+	h.assertPixel(5, 2, color.Transparent)
+	h.key(tcell.KeyEnter)
+	h.assertPixel(5, 2, color.White)
+	h.assertPixel(7, 3, color.White)
+	h.assertPixel(5, 4, color.Transparent)
+	if h.c.selection != image.Rect(5, 2, 8, 4) {
+		t.Errorf("Expected the pasted area to be selected, got %v", h.c.selection)
+	}
+	h.keys("z")
+	h.assertPixel(5, 2, color.Transparent)
+
+	// clear and cut only change the selection
+	h.key(tcell.KeyEscape)
+	h.keys("aaaa").keys("m").keys("m")
+	if h.c.selection != image.Rect(1, 2, 2, 3) {
+		t.Fatalf("Expected single pixel selection, got %v", h.c.selection)
+	}
+	h.key(tcell.KeyDelete)
+	h.assertPixel(1, 2, color.Transparent)
+	h.assertPixel(2, 2, color.White)
+	h.keys("d").keys("m").keys("m").keys("X")
+	h.assertPixel(2, 2, color.Transparent)
+	h.assertPixel(3, 2, color.White)
+	if h.c.clipboard == nil || !sameColor(h.c.clipboard.pixels[image.Pt(0, 0)], color.White) {
+		t.Errorf("Expected the cut pixel in the clipboard")
+	}
+	// escape drops a floating paste
+	h.keys("V").key(tcell.KeyEscape)
+	if h.c.currentState != stateDrawing || h.c.preview != nil {
+		t.Errorf("Expected the paste to be dropped")
+	}
+	// the ticks stop without a selection
+	h.keys("M").event(tcell.NewEventInterrupt(antsTick{}))
+	if h.c.antsScheduled {
+		t.Errorf("Expected no ticks without a selection")
+	}
+}
+
+func Test_CmdPxl_systemClipboard(t *testing.T) {
+	i, _ := createImage("4,6")
+	h := newHarness(t, "test.png", i, 80, 24)
+	h.keys("Y")
+	if !strings.HasPrefix(h.c.message, "clipboard:") {
+		t.Errorf("Expected an error without a terminal, got %q", h.c.message)
+	}
+
+	var terminal strings.Builder
+	h.c.terminal = &terminal
+	h.keys("e").keys("m").keys("ds").keys("m").keys("Y")
+	out := terminal.String()
+	if !strings.HasPrefix(out, "\x1b]52;c;") || !strings.HasSuffix(out, "\a") {
+		t.Fatalf("Expected an OSC 52 sequence, got %q", out)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(out, "\x1b]52;c;"), "\a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Bounds().Dx() != 2 || m.Bounds().Dy() != 2 {
+		t.Errorf("Expected the 2x2 selection to be copied, got %v", m.Bounds())
+	}
+	if got := m.At(m.Bounds().Min.X, m.Bounds().Min.Y); !sameColor(got, color.White) {
+		t.Errorf("Expected the painted pixel to be copied, got %v", got)
+	}
+	h.assertGolden("clipboard")
+	h.keys("w")
+	if h.c.message != "" {
+		t.Errorf("Expected the message to be cleared, got %q", h.c.message)
+	}
+}
+
+func Test_CmdPxl_transform(t *testing.T) {
+	i, _ := createImage("4,6")
+	h := newHarness(t, "test.png", i, 80, 24)
+	h.keys("e").keys("T").keys("ss").key(tcell.KeyEnter)
+	if h.c.imageWidth != 4 || h.c.imageHeight != 6 {
+		t.Fatalf("Expected the rotated image to be 4x6, got %dx%d", h.c.imageWidth, h.c.imageHeight)
+	}
+	h.assertPixel(3, 0, color.White)
+	if canvas := h.c.imageBox.getCanvas(); canvas.Dx()+1 != 8 || canvas.Dy()+1 != 6 {
+		t.Errorf("Expected the canvas to fit the rotated image, got %v", canvas)
+	}
+
+	// resize anchored at the top in the dialog
+	h.keys("T").keys("w").key(tcell.KeyEnter).keys("dd").keys("s").keys("aa").keys("s").keys("d")
+	h.assertGolden("resize")
+	h.key(tcell.KeyEnter)
+	if h.c.imageWidth != 6 || h.c.imageHeight != 4 {
+		t.Fatalf("Expected 6x4 image, got %dx%d", h.c.imageWidth, h.c.imageHeight)
+	}
+	h.assertPixel(4, 0, color.White)
+	if h.c.cursor.Y > 3 {
+		t.Errorf("Expected the cursor inside of the image, got %v", h.c.cursor)
+	}
+
+	// the anchor cycles through the 3x3 grid, A/D move by a row
+	h.keys("T").keys("w").key(tcell.KeyEnter).keys("ss").keys("dddd")
+	for _, step := range []struct {
+		key  string
+		want anchor
+	}{{"D", anchorBottom}, {"A", anchorCenter}, {"A", anchorTop}, {"A", anchorBottom}, {"D", anchorTop}, {"a", anchorTopLeft}, {"a", anchorBottomRight}} {
+		h.keys(step.key)
+		if h.c.resize.anchor != step.want {
+			t.Errorf("Expected the %s anchor after %s, got %s", step.want, step.key, h.c.resize.anchor)
+		}
+	}
+	h.key(tcell.KeyEscape)
+
+	// crop needs a selection
+	h.keys("T").keys("ssss").key(tcell.KeyEnter)
+	if h.c.message != "nothing selected" || h.c.imageWidth != 6 {
+		t.Errorf("Expected crop without a selection to fail, got %q", h.c.message)
+	}
+
+	// undo restores the size
+	h.keys("zz")
+	if h.c.imageWidth != 6 || h.c.imageHeight != 4 {
+		t.Errorf("Expected undo to restore the 6x4 size, got %dx%d", h.c.imageWidth, h.c.imageHeight)
+	}
+	h.assertPixel(0, 0, color.White)
+}
+
+func Test_CmdPxl_scale(t *testing.T) {
+	i, _ := createImage("2,3")
+	h := newHarness(t, "test.png", i, 80, 24)
+	h.keys("L").keys("n").key(tcell.KeyEscape).keys("e")
+	h.keys("S").keys("s").key(tcell.KeyEnter)
+	if h.c.imageWidth != 9 || h.c.imageHeight != 6 {
+		t.Fatalf("Expected 9x6 image, got %dx%d", h.c.imageWidth, h.c.imageHeight)
+	}
+	if len(h.c.m.layers) != 1 {
+		t.Errorf("Expected the layers to be flattened, got %d", len(h.c.m.layers))
+	}
+	h.assertPixel(2, 2, color.White)
+	h.assertPixel(3, 0, color.Transparent)
+	h.keys("z")
+	if h.c.imageWidth != 3 || len(h.c.m.layers) != 2 {
+		t.Errorf("Expected undo to restore the layers, got width %d with %d layers", h.c.imageWidth, len(h.c.m.layers))
+	}
+}
+
+func Test_CmdPxl_palette(t *testing.T) {
+	i, _ := createImage("2,3")
+	h := newHarness(t, "test.png", i, 80, 24)
+	h.keys("e").keys("o").keys("d").keys("e")
+	gray := h.c.penColor.c
+	h.keys("P")
+	if len(h.c.m.palette) != 3 {
+		t.Fatalf("Expected 3 palette entries, got %v", h.c.m.palette)
+	}
+	h.assertGolden("palette")
+
+	// the gray pen is already an entry
+	h.keys("a").keys("r")
+	h.assertPixel(0, 0, color.White)
+	if !sameColor(h.c.m.palette[1], gray) || h.c.message != "the color is already entry #1" {
+		t.Errorf("Expected the repeated color to be rejected, got %v %q", h.c.m.palette, h.c.message)
+	}
+
+	// the pen keeps free colors, they are painted with the closest entry
+	h.key(tcell.KeyEscape).keys("oooo")
+	dark := h.c.penColor.c
+	if sameColor(h.c.m.palette.Convert(dark), dark) {
+		t.Fatalf("Expected a pen color outside of the palette, got %v", dark)
+	}
+	x, y := h.c.cursor.X, h.c.cursor.Y
+	h.keys("e")
+	h.assertPixel(x, y, h.c.m.palette.Convert(dark))
+	h.keys("z")
+
+	// recolor the white entry with the pen
+	h.keys("P").keys("aaa").keys("r")
+	h.assertPixel(0, 0, dark)
+	h.keys("z")
+	h.assertPixel(0, 0, color.White)
+
+	// and add it to the palette
+	h.keys("n")
+	if len(h.c.m.palette) != 4 || !sameColor(h.c.m.palette[3], dark) || h.c.paletteIndex != 3 {
+		t.Errorf("Expected the pen to be added as entry 3, got %v", h.c.m.palette)
+	}
+	h.key(tcell.KeyEscape).keys("e")
+	h.assertPixel(x, y, dark)
+	h.keys("z").keys("z")
+	if len(h.c.m.palette) != 3 {
+		t.Errorf("Expected undo to remove the entry, got %v", h.c.m.palette)
+	}
+
+	h.keys("x").keys("y")
+	pm, ok := h.saved.(*layeredImage).export().(*image.Paletted)
+	if !ok {
+		t.Fatalf("Expected an indexed image to be saved, got %T", h.saved)
+	}
+	if pm.ColorIndexAt(0, 0) != 0 || pm.ColorIndexAt(1, 0) != 1 || pm.ColorIndexAt(2, 0) != 2 {
+		t.Errorf("Expected indices 0 1 2, got %v", pm.Pix[:3])
+	}
+}
+
+func Test_CmdPxl_frames(t *testing.T) {
+	i, _ := createImage("2,3")
+	h := newHarness(t, "test.png", i, 80, 24)
+	h.keys("e").keys("F").keys("n").keys("d")
+	h.assertGolden("frames")
+	h.key(tcell.KeyEscape)
+	if h.c.m.frameCount() != 2 || h.c.m.frame != 1 || h.c.m.duration() != 110*time.Millisecond {
+		t.Fatalf("Expected the second of 2 frames with 110ms, got %d of %d with %s", h.c.m.frame, h.c.m.frameCount(), h.c.m.duration())
+	}
+	h.assertPixel(0, 0, color.Transparent)
+
+	// the previous frame shows through the onion skin
+	h.keys("O")
+	p := h.c.imageBox.getPoint(0, 0)
+	_, _, style, _ := h.s.GetContent(p.X, p.Y)
+	_, bg, _ := style.Decompose()
+	want := tcell.FromImageColor(displayColor(image.Pt(0, 0), color.NRGBA{255, 255, 255, 77}))
+	if bg != want {
+		t.Errorf("Expected onion skin color %v, got %v", want, bg)
+	}
+
+	h.keys("<")
+	h.assertPixel(0, 0, color.White)
+
+	// playback advances on ticks of the current playback only
+	h.keys("R")
+	h.event(tcell.NewEventInterrupt(playbackTick{h.c.playback}))
+	if h.c.m.frame != 1 {
+		t.Errorf("Expected playback to show frame 1, got %d", h.c.m.frame)
+	}
+	h.event(tcell.NewEventInterrupt(playbackTick{h.c.playback - 1}))
+	if h.c.m.frame != 1 {
+		t.Errorf("Expected a stale tick to be ignored, got frame %d", h.c.m.frame)
+	}
+	// drawing is disabled during the playback
+	h.keys("e")
+	h.assertPixel(0, 0, color.Transparent)
+	h.key(tcell.KeyEscape)
+	if h.c.currentState != stateDrawing {
+		t.Errorf("Expected escape to stop the playback")
+	}
+}
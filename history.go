@@ -0,0 +1,135 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// command is a single undoable user action. Commands are applied before they
+// are pushed to the history.
+type command interface {
+	do(m *layeredImage)
+	undo(m *layeredImage)
+}
+
+// pixelChange records a single pixel overwritten by a command.
+type pixelChange struct {
+	frame int
+	layer int
+	point image.Point
+	from  color.Color
+	to    color.Color
+}
+
+// pixelCommand groups all pixels changed by a single action (a pixel, a
+// stroke, a fill) so they can be undone at once.
+type pixelCommand struct {
+	changes []pixelChange
+	index   map[image.Point]int
+}
+
+func newPixelCommand() *pixelCommand {
+	return &pixelCommand{
+		changes: make([]pixelChange, 0),
+		index:   make(map[image.Point]int),
+	}
+}
+
+// set paints the point and records the color it overwrote. Painting the same
+// point twice keeps the first original color. Indexed images are painted
+// with the closest palette entry.
+func (pc *pixelCommand) set(m *layeredImage, p image.Point, c color.Color) {
+	if m.palette != nil {
+		c = m.palette.Convert(c)
+	}
+	if i, ok := pc.index[p]; ok && pc.changes[i].layer == m.current && pc.changes[i].frame == m.frame {
+		pc.changes[i].to = c
+	} else {
+		pc.index[p] = len(pc.changes)
+		pc.changes = append(pc.changes, pixelChange{m.frame, m.current, p, m.activeLayer().at(p), c})
+	}
+	m.Set(p, c)
+}
+
+func (pc *pixelCommand) empty() bool {
+	return len(pc.changes) == 0
+}
+
+// do and undo switch to the frame of the changes, so the change is visible.
+func (pc *pixelCommand) do(m *layeredImage) {
+	for _, ch := range pc.changes {
+		m.selectFrame(ch.frame)
+		m.layers[ch.layer].set(ch.point, ch.to)
+	}
+}
+
+func (pc *pixelCommand) undo(m *layeredImage) {
+	for i := len(pc.changes) - 1; i >= 0; i-- {
+		ch := pc.changes[i]
+		m.selectFrame(ch.frame)
+		m.layers[ch.layer].set(ch.point, ch.from)
+	}
+}
+
+// recorder is a drawable which records every pixel painted on the image into
+// a pixelCommand.
+type recorder struct {
+	*layeredImage
+	cmd *pixelCommand
+}
+
+func newRecorder(m *layeredImage) *recorder {
+	return &recorder{m, newPixelCommand()}
+}
+
+func (r *recorder) Set(p image.Point, c color.Color) {
+	r.cmd.set(r.layeredImage, p, c)
+}
+
+type history struct {
+	undoStack []command
+	redoStack []command
+}
+
+func newHistory() *history {
+	return &history{
+		undoStack: make([]command, 0),
+		redoStack: make([]command, 0),
+	}
+}
+
+// push adds an already applied command to the history and drops the redo
+// stack.
+func (h *history) push(cmd command) {
+	h.undoStack = append(h.undoStack, cmd)
+	h.redoStack = h.redoStack[:0]
+}
+
+func (h *history) undo(m *layeredImage) bool {
+	l := len(h.undoStack)
+	if l == 0 {
+		return false
+	}
+	cmd := h.undoStack[l-1]
+	h.undoStack = h.undoStack[:l-1]
+	cmd.undo(m)
+	h.redoStack = append(h.redoStack, cmd)
+	return true
+}
+
+func (h *history) redo(m *layeredImage) bool {
+	l := len(h.redoStack)
+	if l == 0 {
+		return false
+	}
+	cmd := h.redoStack[l-1]
+	h.redoStack = h.redoStack[:l-1]
+	cmd.do(m)
+	h.undoStack = append(h.undoStack, cmd)
+	return true
+}
+
+// changed reports if there are any changes which can be undone.
+func (h *history) changed() bool {
+	return len(h.undoStack) > 0
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_history_undoRedo(t *testing.T) {
+	i, _ := createImage("5,5")
+	li := newLayeredImage(i)
+	h := newHistory()
+
+	cmd := newPixelCommand()
+	cmd.set(li, image.Pt(1, 1), color.White)
+	h.push(cmd)
+
+	r := newRecorder(li)
+	floodFill(r, image.Pt(0, 0), li.At(0, 0), color.Black, fillOptions{})
+	h.push(r.cmd)
+
+	if got := li.At(1, 1); !sameColor(got, color.White) {
+		t.Errorf("Expected pixel to stay white after fill, got %v", got)
+	}
+	if !h.undo(li) {
+		t.Fatal("Expected fill to be undone")
+	}
+	if li.layers[0].at(image.Pt(0, 0)).A != 0 {
+		t.Errorf("Expected fill to be removed from the layer")
+	}
+	if got := li.At(1, 1); !sameColor(got, color.White) {
+		t.Errorf("Expected pixel to stay white after undo, got %v", got)
+	}
+	if !h.redo(li) {
+		t.Fatal("Expected fill to be redone")
+	}
+	if got := li.At(4, 4); !sameColor(got, color.Black) {
+		t.Errorf("Expected fill to be redone, got %v", got)
+	}
+	h.undo(li)
+	h.undo(li)
+	if h.changed() || !li.layers[0].isEmpty() {
+		t.Errorf("Expected empty history and layer, got %d pixels", paintedPixels(li.layers[0]))
+	}
+	if h.undo(li) {
+		t.Errorf("Expected nothing to undo")
+	}
+}
+
+func Test_history_pushDropsRedo(t *testing.T) {
+	i, _ := createImage("2,2")
+	li := newLayeredImage(i)
+	h := newHistory()
+
+	cmd := newPixelCommand()
+	cmd.set(li, image.Pt(0, 0), color.White)
+	h.push(cmd)
+	h.undo(li)
+
+	cmd = newPixelCommand()
+	cmd.set(li, image.Pt(1, 0), color.White)
+	h.push(cmd)
+	if h.redo(li) {
+		t.Errorf("Expected redo stack to be dropped after a new command")
+	}
+}
@@ -3,38 +3,277 @@ package main
 import (
 	"image"
 	"image/color"
+	"image/draw"
+	"sort"
 )
 
+// layer is a sparse set of pixels, like previews and pasted content, which is
+// painted over the active layer.
 type layer map[image.Point]color.Color
 
-type layeredImage struct {
-	l layer
+// drawable is an image which can be painted on.
+type drawable interface {
 	image.Image
+	Set(p image.Point, c color.Color)
+}
+
+// points returns the points of the layer sorted by row and column.
+func (l layer) points() []image.Point {
+	result := make([]image.Point, 0, len(l))
+	for pt := range l {
+		result = append(result, pt)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Y != result[j].Y {
+			return result[i].Y < result[j].Y
+		}
+		return result[i].X < result[j].X
+	})
+	return result
+}
+
+// layeredImage is a stack of layers which is composited on the fly.
+type layeredImage struct {
+	// layers are ordered from the bottom to the top
+	layers  []*imageLayer
+	current int
+	bounds  image.Rectangle
+	// palette restricts the colors of indexed images, it is nil for
+	// images with free colors
+	palette color.Palette
+	// indices are the pixels of the loaded indexed image, unchanged pixels
+	// are saved with their index even if the palette repeats their color
+	indices *image.Paletted
+	// frames are the images of an animation. The layers of the current
+	// frame are edited in layers and current, the other frames keep their
+	// own layers.
+	frames []*frame
+	frame  int
+	// loopCount is the number of times animations repeat as in GIF files,
+	// 0 repeats forever
+	loopCount int
+}
+
+// newLayeredImage creates a layered image with a single background layer
+// holding the pixels of m.
+func newLayeredImage(m image.Image) *layeredImage {
+	b := m.Bounds()
+	background := newImageLayer("Background", b)
+	draw.Draw(background.pixels, b, m, b.Min, draw.Src)
+	var palette color.Palette
+	var indices *image.Paletted
+	if pm, ok := m.(*image.Paletted); ok && len(pm.Palette) > 0 {
+		palette = append(color.Palette{}, pm.Palette...)
+		indices = pm
+	}
+	return &layeredImage{
+		layers:  []*imageLayer{background},
+		current: 0,
+		bounds:  b,
+		palette: palette,
+		indices: indices,
+		frames:  []*frame{{duration: defaultFrameDuration}},
+	}
+}
+
+func (li *layeredImage) ColorModel() color.Model {
+	return color.NRGBAModel
+}
+
+func (li *layeredImage) Bounds() image.Rectangle {
+	return li.bounds
 }
 
 func (li *layeredImage) At(x, y int) color.Color {
-	if c, ok := li.l[image.Pt(x, y)]; ok {
-		return c
+	return li.atWith(image.Pt(x, y), nil)
+}
+
+// atWith returns the composited color of the pixel with the overlay painted
+// over the active layer.
+func (li *layeredImage) atWith(p image.Point, overlay layer) color.Color {
+	if len(li.layers) == 1 && li.layers[0].isPlain() {
+		if c, ok := overlay[p]; ok {
+			return c
+		}
+		return li.layers[0].at(p)
 	}
-	return li.Image.At(x, y)
+	return li.composite(p, li.layers, li.current, overlay)
 }
 
+// Set paints the pixel on the current layer.
 func (li *layeredImage) Set(p image.Point, c color.Color) {
-	li.l[p] = c
+	li.activeLayer().set(p, c)
+}
+
+func (li *layeredImage) activeLayer() *imageLayer {
+	return li.layers[li.current]
+}
+
+// flatten returns the composited image.
+func (li *layeredImage) flatten() *image.NRGBA {
+	result := image.NewNRGBA(li.bounds)
+	for y := li.bounds.Min.Y; y < li.bounds.Max.Y; y++ {
+		for x := li.bounds.Min.X; x < li.bounds.Max.X; x++ {
+			result.Set(x, y, li.At(x, y))
+		}
+	}
+	return result
+}
+
+// snapshot returns a copy of the image which shares the pixels of the layers.
+// Only pixel commands paint in place, all other changes give the layers new
+// pixels. The history undoes the pixel commands made after a snapshot before
+// the snapshot is restored, so its pixels are always up to date.
+func (li *layeredImage) snapshot() *layeredImage {
+	var palette color.Palette
+	if li.palette != nil {
+		palette = append(color.Palette{}, li.palette...)
+	}
+	frames := make([]*frame, len(li.frames))
+	for i, f := range li.frames {
+		frames[i] = f.snapshot()
+	}
+	return &layeredImage{
+		layers:    snapshotLayers(li.layers),
+		current:   li.current,
+		bounds:    li.bounds,
+		palette:   palette,
+		indices:   li.indices,
+		frames:    frames,
+		frame:     li.frame,
+		loopCount: li.loopCount,
+	}
+}
+
+// export returns the image to save, indexed images are saved with their
+// palette.
+func (li *layeredImage) export() image.Image {
+	if li.palette == nil {
+		return li.flatten()
+	}
+	result := image.NewPaletted(li.bounds, li.palette)
+	for y := li.bounds.Min.Y; y < li.bounds.Max.Y; y++ {
+		for x := li.bounds.Min.X; x < li.bounds.Max.X; x++ {
+			result.SetColorIndex(x, y, li.paletteIndex(x, y))
+		}
+	}
+	return result
+}
+
+// paletteIndex returns the palette entry of the pixel, the index it was
+// loaded with while the entry still has its color.
+func (li *layeredImage) paletteIndex(x, y int) uint8 {
+	c := li.At(x, y)
+	if li.indices != nil && image.Pt(x, y).In(li.indices.Rect) {
+		if i := int(li.indices.ColorIndexAt(x, y)); i < len(li.palette) && sameColor(li.palette[i], c) {
+			return uint8(i)
+		}
+	}
+	return uint8(li.palette.Index(c))
+}
+
+// fillOptions controls which pixels are replaced by floodFill.
+type fillOptions struct {
+	// tolerance is the maximum distance between the start color and a
+	// replaced color, in the range 0 (exact match) to 1 (any color).
+	tolerance float64
+	space     colorSpace
+	// diagonal enables 8-way connectivity.
+	diagonal bool
+	// global replaces every matching pixel regardless of connectivity.
+	global bool
+}
+
+func (fo fillOptions) connectivity() string {
+	if fo.diagonal {
+		return "8-way"
+	}
+	return "4-way"
+}
+
+// clippedImage limits reading and painting of an image to a rectangle.
+type clippedImage struct {
+	drawable
+	r image.Rectangle
+}
+
+func (ci clippedImage) Bounds() image.Rectangle {
+	return ci.r.Intersect(ci.drawable.Bounds())
+}
+
+func (ci clippedImage) Set(p image.Point, c color.Color) {
+	if p.In(ci.Bounds()) {
+		ci.drawable.Set(p, c)
+	}
 }
 
-func floodFill(m *layeredImage, p image.Point, fromColor, toColor color.Color) {
-	m.Set(p, toColor)
+// floodFill replaces the area of pixels matching fromColor, connected to p,
+// with toColor.
+func floodFill(m drawable, p image.Point, fromColor, toColor color.Color, opts fillOptions) {
 	b := m.Bounds()
-	for _, pt := range []image.Point{
-		image.Pt(p.X-1, p.Y),
-		image.Pt(p.X+1, p.Y),
-		image.Pt(p.X, p.Y-1),
-		image.Pt(p.X, p.Y+1),
-	} {
-		if pt.In(b) && m.At(pt.X, pt.Y) == fromColor {
-			m.Set(pt, toColor)
-			floodFill(m, pt, fromColor, toColor)
+	if !p.In(b) {
+		return
+	}
+	match := func(x, y int) bool {
+		return colorMatches(m.At(x, y), fromColor, opts)
+	}
+
+	if opts.global {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if match(x, y) {
+					m.Set(image.Pt(x, y), toColor)
+				}
+			}
+		}
+		return
+	}
+
+	// Scanline fill: every seed is expanded to a horizontal run, the rows
+	// above and below the run are scanned for new seeds.
+	w := b.Dx()
+	visited := make([]bool, w*b.Dy())
+	isVisited := func(x, y int) bool {
+		return visited[(y-b.Min.Y)*w+x-b.Min.X]
+	}
+	spread := 0
+	if opts.diagonal {
+		spread = 1
+	}
+	seeds := []image.Point{p}
+	for len(seeds) > 0 {
+		seed := seeds[len(seeds)-1]
+		seeds = seeds[:len(seeds)-1]
+		if isVisited(seed.X, seed.Y) || !match(seed.X, seed.Y) {
+			continue
+		}
+		lx := seed.X
+		for lx > b.Min.X && !isVisited(lx-1, seed.Y) && match(lx-1, seed.Y) {
+			lx--
+		}
+		rx := seed.X
+		for rx < b.Max.X-1 && !isVisited(rx+1, seed.Y) && match(rx+1, seed.Y) {
+			rx++
+		}
+		for x := lx; x <= rx; x++ {
+			visited[(seed.Y-b.Min.Y)*w+x-b.Min.X] = true
+			m.Set(image.Pt(x, seed.Y), toColor)
+		}
+		for _, y := range []int{seed.Y - 1, seed.Y + 1} {
+			if y < b.Min.Y || y >= b.Max.Y {
+				continue
+			}
+			inRun := false
+			for x := max(lx-spread, b.Min.X); x <= min(rx+spread, b.Max.X-1); x++ {
+				if !isVisited(x, y) && match(x, y) {
+					if !inRun {
+						seeds = append(seeds, image.Pt(x, y))
+						inRun = true
+					}
+				} else {
+					inRun = false
+				}
+			}
 		}
 	}
 }
@@ -11,17 +11,17 @@ import (
 
 func Test_Image_Save(t *testing.T) {
 	i, _ := createImage("5,5")
-	li := layeredImage{make(layer), i}
+	li := newLayeredImage(i)
 	li.Set(image.Pt(0, 0), color.White)
 	b := new(bytes.Buffer)
-	png.Encode(b, &li)
+	png.Encode(b, li)
 	image1 := base64.StdEncoding.EncodeToString(b.Bytes())
 
 	i2, _ := createImage("5,5")
-	li2 := layeredImage{make(layer), i2}
+	li2 := newLayeredImage(i2)
 	li2.Set(image.Pt(1, 0), color.White)
 	b2 := new(bytes.Buffer)
-	png.Encode(b2, &li2)
+	png.Encode(b2, li2)
 	image2 := base64.StdEncoding.EncodeToString(b2.Bytes())
 
 	if image1 == image2 {
@@ -50,13 +50,13 @@ func Test_floodFill(t *testing.T) {
 	}
 	for _, tt := range tests {
 		i, _ := createImage("5,5")
-		li := layeredImage{make(layer), i}
+		li := newLayeredImage(i)
 		fromColor := li.At(tt.args.p.X, tt.args.p.Y)
 		t.Run(tt.name, func(t *testing.T) {
-			floodFill(&li, tt.args.p, fromColor, tt.args.toColor)
+			floodFill(li, tt.args.p, fromColor, tt.args.toColor, fillOptions{})
 		})
 		got := li.At(tt.checkPoint.X, tt.checkPoint.Y)
-		if got != tt.args.toColor {
+		if !sameColor(got, tt.args.toColor) {
 			t.Errorf("Expected to find color %v at %s but found %v instead", tt.args.toColor, tt.checkPoint, got)
 		}
 	}
@@ -64,10 +64,86 @@ func Test_floodFill(t *testing.T) {
 
 func Benchmark_floodFill(b *testing.B) {
 	i, _ := createImage("100,100")
-	li := layeredImage{make(layer), i}
+	li := newLayeredImage(i)
 	fromColor := li.At(0, 0)
 	for n := 0; n < b.N; n++ {
-		li := layeredImage{make(layer), i}
-		floodFill(&li, image.Pt(0, 0), fromColor, color.Black)
+		li := newLayeredImage(i)
+		floodFill(li, image.Pt(0, 0), fromColor, color.Black, fillOptions{})
+	}
+}
+
+func Test_floodFill_options(t *testing.T) {
+	gray := color.RGBA{10, 10, 10, 255}
+	tests := []struct {
+		name       string
+		opts       fillOptions
+		checkPoint image.Point
+		want       color.Color
+	}{
+		{
+			"does not cross diagonal with 4-way connectivity",
+			fillOptions{},
+			image.Pt(3, 3),
+			color.RGBA{0, 0, 0, 255},
+		},
+		{
+			"crosses diagonal with 8-way connectivity",
+			fillOptions{diagonal: true},
+			image.Pt(3, 3),
+			color.White,
+		},
+		{
+			"stops at similar color without tolerance",
+			fillOptions{},
+			image.Pt(1, 1),
+			gray,
+		},
+		{
+			"includes similar color with tolerance",
+			fillOptions{tolerance: 0.1},
+			image.Pt(1, 1),
+			color.White,
+		},
+		{
+			"includes similar color with tolerance in lab",
+			fillOptions{tolerance: 0.1, space: colorSpaceLab},
+			image.Pt(1, 1),
+			color.White,
+		},
+		{
+			"replaces unconnected pixels in global mode",
+			fillOptions{global: true},
+			image.Pt(3, 3),
+			color.White,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// black image with a red diagonal wall and a gray pixel in the top corner
+			m := image.NewRGBA(image.Rect(0, 0, 5, 5))
+			for y := 0; y < 5; y++ {
+				for x := 0; x < 5; x++ {
+					m.Set(x, y, color.RGBA{0, 0, 0, 255})
+				}
+			}
+			for x := 0; x < 4; x++ {
+				m.Set(x, 3-x, color.RGBA{255, 0, 0, 255})
+			}
+			m.Set(1, 1, gray)
+			li := newLayeredImage(m)
+			floodFill(li, image.Pt(0, 0), li.At(0, 0), color.White, tt.opts)
+			if got := li.At(tt.checkPoint.X, tt.checkPoint.Y); !sameColor(got, tt.want) {
+				t.Errorf("Expected to find color %v at %s but found %v instead", tt.want, tt.checkPoint, got)
+			}
+		})
+	}
+}
+
+func Test_floodFill_large(t *testing.T) {
+	i, _ := createImage("1000,1000")
+	li := newLayeredImage(i)
+	floodFill(li, image.Pt(500, 500), li.At(0, 0), color.White, fillOptions{})
+	if n := paintedPixels(li.layers[0]); n != 1000*1000 {
+		t.Errorf("Expected %d filled pixels, got %d", 1000*1000, n)
 	}
 }
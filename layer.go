@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+type blendMode int
+
+const (
+	blendNormal blendMode = iota
+	blendMultiply
+	blendScreen
+	blendOverlay
+	blendModeCount
+)
+
+func (bm blendMode) String() string {
+	switch bm {
+	case blendMultiply:
+		return "multiply"
+	case blendScreen:
+		return "screen"
+	case blendOverlay:
+		return "overlay"
+	}
+	return "normal"
+}
+
+// blend mixes a single channel of the backdrop cb with the source cs, both in
+// the range 0 to 1.
+func (bm blendMode) blend(cb, cs float64) float64 {
+	switch bm {
+	case blendMultiply:
+		return cb * cs
+	case blendScreen:
+		return cb + cs - cb*cs
+	case blendOverlay:
+		if cb <= 0.5 {
+			return 2 * cb * cs
+		}
+		return 1 - 2*(1-cb)*(1-cs)
+	}
+	return cs
+}
+
+type imageLayer struct {
+	name    string
+	visible bool
+	opacity float64
+	blend   blendMode
+	// pixels cover the bounds of the image, transparent pixels are not
+	// painted
+	pixels *image.NRGBA
+}
+
+func newImageLayer(name string, b image.Rectangle) *imageLayer {
+	return &imageLayer{
+		name:    name,
+		visible: true,
+		opacity: 1,
+		blend:   blendNormal,
+		pixels:  image.NewNRGBA(b),
+	}
+}
+
+// isPlain reports if the layer can be displayed without compositing.
+func (il *imageLayer) isPlain() bool {
+	return il.visible && il.opacity == 1 && il.blend == blendNormal
+}
+
+// at returns the color of the pixel which is transparent when not painted.
+func (il *imageLayer) at(p image.Point) color.NRGBA {
+	return il.pixels.NRGBAAt(p.X, p.Y)
+}
+
+// set paints the pixel, pixels outside of the layer are ignored.
+func (il *imageLayer) set(p image.Point, c color.Color) {
+	il.pixels.Set(p.X, p.Y, c)
+}
+
+// isEmpty reports if no pixel of the layer is painted.
+func (il *imageLayer) isEmpty() bool {
+	for i := 3; i < len(il.pixels.Pix); i += 4 {
+		if il.pixels.Pix[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// recolor replaces the painted pixels with the result of fn. The layer gets
+// new pixels, snapshots keep the old ones.
+func (il *imageLayer) recolor(fn func(c color.NRGBA) color.Color) {
+	pixels := image.NewNRGBA(il.pixels.Rect)
+	b := pixels.Rect
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if c := il.pixels.NRGBAAt(x, y); c.A != 0 {
+				pixels.Set(x, y, fn(c))
+			}
+		}
+	}
+	il.pixels = pixels
+}
+
+func (il *imageLayer) clone() *imageLayer {
+	pixels := image.NewNRGBA(il.pixels.Rect)
+	copy(pixels.Pix, il.pixels.Pix)
+	return &imageLayer{
+		name:    il.name,
+		visible: il.visible,
+		opacity: il.opacity,
+		blend:   il.blend,
+		pixels:  pixels,
+	}
+}
+
+// snapshotLayers copies the layers, the copies share the pixels.
+func snapshotLayers(layers []*imageLayer) []*imageLayer {
+	if layers == nil {
+		return nil
+	}
+	result := make([]*imageLayer, len(layers))
+	for i, l := range layers {
+		c := *l
+		result[i] = &c
+	}
+	return result
+}
+
+func (il *imageLayer) String() string {
+	visible := "○"
+	if il.visible {
+		visible = "●"
+	}
+	return fmt.Sprintf("%s %-12s %3.0f%% %s", visible, il.name, il.opacity*100, il.blend)
+}
+
+// rgba is a non alpha-premultiplied color with channels in the range 0 to 1.
+type rgba struct {
+	r, g, b, a float64
+}
+
+func newRGBA(c color.Color) rgba {
+	n := color.NRGBA64Model.Convert(c).(color.NRGBA64)
+	return rgba{
+		float64(n.R) / 0xffff,
+		float64(n.G) / 0xffff,
+		float64(n.B) / 0xffff,
+		float64(n.A) / 0xffff,
+	}
+}
+
+func (c rgba) color() color.NRGBA {
+	channel := func(v float64) uint8 {
+		return uint8(math.Round(math.Max(0, math.Min(1, v)) * 255))
+	}
+	return color.NRGBA{channel(c.r), channel(c.g), channel(c.b), channel(c.a)}
+}
+
+// over composites src with the given opacity and blend mode over the
+// backdrop.
+func (c rgba) over(src rgba, opacity float64, mode blendMode) rgba {
+	sa := src.a * opacity
+	if sa == 0 {
+		return c
+	}
+	ao := sa + c.a*(1-sa)
+	mix := func(cb, cs float64) float64 {
+		// the blended color is only used where the backdrop is opaque
+		cs = (1-c.a)*cs + c.a*mode.blend(cb, cs)
+		return (sa*cs + c.a*cb*(1-sa)) / ao
+	}
+	return rgba{mix(c.r, src.r), mix(c.g, src.g), mix(c.b, src.b), ao}
+}
+
+// composite blends the pixel at p of all visible layers. Pixels of the
+// overlay replace the pixels of the layer at index overlayLayer.
+func (li *layeredImage) composite(p image.Point, layers []*imageLayer, overlayLayer int, overlay layer) color.Color {
+	result := rgba{}
+	for i, l := range layers {
+		if !l.visible {
+			continue
+		}
+		var c color.Color = l.at(p)
+		if i == overlayLayer {
+			if oc, found := overlay[p]; found {
+				c = oc
+			}
+		}
+		result = result.over(newRGBA(c), l.opacity, l.blend)
+	}
+	return result.color()
+}
+
+// layerImage is a read only view of a single layer.
+type layerImage struct {
+	*imageLayer
+	bounds image.Rectangle
+}
+
+// activeLayerImage returns a view of the current layer.
+func (li *layeredImage) activeLayerImage() image.Image {
+	return layerImage{li.activeLayer(), li.bounds}
+}
+
+func (li layerImage) ColorModel() color.Model {
+	return color.NRGBAModel
+}
+
+func (li layerImage) Bounds() image.Rectangle {
+	return li.bounds
+}
+
+func (li layerImage) At(x, y int) color.Color {
+	return li.at(image.Pt(x, y))
+}
+
+// addLayer inserts a new empty layer above the current one and selects it.
+func (li *layeredImage) addLayer() {
+	l := newImageLayer(li.newLayerName(), li.bounds)
+	li.current++
+	li.layers = append(li.layers[:li.current], append([]*imageLayer{l}, li.layers[li.current:]...)...)
+}
+
+func (li *layeredImage) newLayerName() string {
+	for n := len(li.layers); ; n++ {
+		name := fmt.Sprintf("Layer %d", n)
+		taken := false
+		for _, l := range li.layers {
+			taken = taken || l.name == name
+		}
+		if !taken {
+			return name
+		}
+	}
+}
+
+// deleteLayer removes the current layer unless it is the last one.
+func (li *layeredImage) deleteLayer() bool {
+	if len(li.layers) < 2 {
+		return false
+	}
+	li.layers = append(li.layers[:li.current], li.layers[li.current+1:]...)
+	li.current = max(0, li.current-1)
+	return true
+}
+
+// moveLayer moves the current layer up or down the stack.
+func (li *layeredImage) moveLayer(dir direction) bool {
+	target := li.current - 1
+	if dir == dirIncrease {
+		target = li.current + 1
+	}
+	if target < 0 || target >= len(li.layers) {
+		return false
+	}
+	li.layers[li.current], li.layers[target] = li.layers[target], li.layers[li.current]
+	li.current = target
+	return true
+}
+
+// mergeDown composites the current layer into the layer below it.
+func (li *layeredImage) mergeDown() bool {
+	if li.current == 0 {
+		return false
+	}
+	upper := li.layers[li.current]
+	lower := li.layers[li.current-1]
+	merged := newImageLayer(lower.name, li.bounds)
+	merged.visible = lower.visible
+	merged.blend = lower.blend
+	pair := []*imageLayer{lower, upper}
+	for y := li.bounds.Min.Y; y < li.bounds.Max.Y; y++ {
+		for x := li.bounds.Min.X; x < li.bounds.Max.X; x++ {
+			p := image.Pt(x, y)
+			merged.set(p, li.composite(p, pair, -1, nil))
+		}
+	}
+	li.layers[li.current-1] = merged
+	li.deleteLayer()
+	return true
+}
+
+// layersCommand is an undoable change of the layer stack stored as a pair of
+// snapshots. Layers which are not changed share their pixels with the image,
+// so only the pixels of changed layers are kept twice.
+type layersCommand struct {
+	before *layeredImage
+	after  *layeredImage
+}
+
+// changeLayers applies change to m and returns the command to undo it, or nil
+// if nothing was changed.
+func changeLayers(m *layeredImage, change func(m *layeredImage) bool) command {
+	before := m.snapshot()
+	if !change(m) {
+		return nil
+	}
+	return &layersCommand{before, m.snapshot()}
+}
+
+func (lc *layersCommand) do(m *layeredImage) {
+	*m = *lc.after.snapshot()
+}
+
+func (lc *layersCommand) undo(m *layeredImage) {
+	*m = *lc.before.snapshot()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+const opacityStep = 0.1
+
+// changeLayers applies a change of the layer stack and adds it to the
+// history.
+func (c *CmdPxl) changeLayers(change func(m *layeredImage) bool) {
+	c.do(changeLayers(c.m, change))
+}
+
+func (c *CmdPxl) handleLayerKey(ev *tcell.EventKey) {
+	switch {
+	case ev.Key() == tcell.KeyEscape || ev.Key() == tcell.KeyEnter || ev.Rune() == 'L' || ev.Rune() == 'x':
+		c.currentState = stateDrawing
+		c.s.Clear()
+	case ev.Key() == tcell.KeyUp || ev.Rune() == 'w':
+		c.m.current = min(c.m.current+1, len(c.m.layers)-1)
+	case ev.Key() == tcell.KeyDown || ev.Rune() == 's':
+		c.m.current = max(c.m.current-1, 0)
+	case ev.Rune() == 'W':
+		c.changeLayers(func(m *layeredImage) bool { return m.moveLayer(dirIncrease) })
+	case ev.Rune() == 'S':
+		c.changeLayers(func(m *layeredImage) bool { return m.moveLayer(dirDecrease) })
+	case ev.Rune() == 'n':
+		c.changeLayers(func(m *layeredImage) bool {
+			m.addLayer()
+			return true
+		})
+	case ev.Rune() == 'X':
+		c.changeLayers(func(m *layeredImage) bool { return m.deleteLayer() })
+	case ev.Rune() == 'm':
+		c.changeLayers(func(m *layeredImage) bool { return m.mergeDown() })
+	case ev.Rune() == 'v':
+		c.changeLayers(func(m *layeredImage) bool {
+			m.activeLayer().visible = !m.activeLayer().visible
+			return true
+		})
+	case ev.Key() == tcell.KeyLeft || ev.Rune() == 'a':
+		c.changeLayerOpacity(-opacityStep)
+	case ev.Key() == tcell.KeyRight || ev.Rune() == 'd':
+		c.changeLayerOpacity(opacityStep)
+	case ev.Rune() == 'b':
+		c.changeLayers(func(m *layeredImage) bool {
+			m.activeLayer().blend = (m.activeLayer().blend + 1) % blendModeCount
+			return true
+		})
+	case ev.Rune() == 'z':
+		c.history.undo(c.m)
+	case ev.Rune() == 'y':
+		c.history.redo(c.m)
+	}
+}
+
+func (c *CmdPxl) changeLayerOpacity(delta float64) {
+	c.changeLayers(func(m *layeredImage) bool {
+		l := m.activeLayer()
+		opacity := math.Round(math.Max(0, math.Min(1, l.opacity+delta))*100) / 100
+		if opacity == l.opacity {
+			return false
+		}
+		l.opacity = opacity
+		return true
+	})
+}
+
+// drawLayerPanel draws the layer stack with the top layer first.
+func (c *CmdPxl) drawLayerPanel() *drawBox {
+	items := make([]string, len(c.m.layers))
+	for i, l := range c.m.layers {
+		items[len(items)-1-i] = l.String()
+	}
+	mn := newMenu("Layers", items)
+	mn.selected = len(items) - 1 - c.m.current
+	return mn.draw(c.s, 0, 0, c.interfaceStyle,
+		"[w/s] select [W/S] move",
+		"[n] new [X] delete [m] merge down",
+		"[v] visible [a/d] opacity [b] blend",
+		"[z/y] undo/redo [esc] close",
+	)
+}
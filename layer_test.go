@@ -0,0 +1,129 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_layeredImage_composite(t *testing.T) {
+	gray := color.NRGBA{128, 128, 128, 255}
+	tests := []struct {
+		name    string
+		opacity float64
+		blend   blendMode
+		top     color.Color
+		want    color.NRGBA
+	}{
+		{"normal", 1, blendNormal, color.NRGBA{255, 0, 0, 255}, color.NRGBA{255, 0, 0, 255}},
+		{"normal with opacity", 0.5, blendNormal, color.NRGBA{0, 0, 0, 255}, color.NRGBA{64, 64, 64, 255}},
+		{"multiply", 1, blendMultiply, color.NRGBA{255, 0, 255, 255}, color.NRGBA{128, 0, 128, 255}},
+		{"screen", 1, blendScreen, color.NRGBA{0, 0, 0, 255}, gray},
+		{"overlay", 1, blendOverlay, color.NRGBA{255, 255, 255, 255}, color.NRGBA{255, 255, 255, 255}},
+		{"transparent top", 1, blendMultiply, color.NRGBA{}, gray},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+			m.Set(0, 0, gray)
+			li := newLayeredImage(m)
+			li.addLayer()
+			li.activeLayer().opacity = tt.opacity
+			li.activeLayer().blend = tt.blend
+			li.Set(image.Pt(0, 0), tt.top)
+			if got := li.At(0, 0); got != tt.want {
+				t.Errorf("layeredImage.At() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_layeredImage_layers(t *testing.T) {
+	i, _ := createImage("2,2")
+	li := newLayeredImage(i)
+	h := newHistory()
+	change := func(fn func(m *layeredImage) bool) {
+		if cmd := changeLayers(li, fn); cmd != nil {
+			h.push(cmd)
+		}
+	}
+
+	change(func(m *layeredImage) bool {
+		m.addLayer()
+		return true
+	})
+	li.Set(image.Pt(0, 0), color.White)
+	if len(li.layers) != 2 || li.current != 1 || li.layers[1].name != "Layer 1" {
+		t.Fatalf("Expected new layer to be added on top, got %d layers", len(li.layers))
+	}
+	if change(func(m *layeredImage) bool { return m.moveLayer(dirIncrease) }); li.current != 1 {
+		t.Errorf("Expected top layer not to move up")
+	}
+	change(func(m *layeredImage) bool { return m.moveLayer(dirDecrease) })
+	if li.current != 0 || li.layers[0].name != "Layer 1" {
+		t.Errorf("Expected layer to move down")
+	}
+	change(func(m *layeredImage) bool {
+		m.activeLayer().visible = false
+		return true
+	})
+	if got := li.At(0, 0); got != (color.NRGBA{}) {
+		t.Errorf("Expected hidden layer not to be drawn, got %v", got)
+	}
+	h.undo(li)
+	h.undo(li)
+	if li.current != 1 || !li.layers[1].visible {
+		t.Errorf("Expected undo to restore the layer order and visibility")
+	}
+	change(func(m *layeredImage) bool { return m.mergeDown() })
+	if len(li.layers) != 1 || !sameColor(li.layers[0].at(image.Pt(0, 0)), color.White) {
+		t.Errorf("Expected layers to be merged, got %d layers", len(li.layers))
+	}
+	if change(func(m *layeredImage) bool { return m.deleteLayer() }); len(li.layers) != 1 {
+		t.Errorf("Expected last layer not to be deleted")
+	}
+	if got := li.flatten().At(0, 0); !sameColor(got, color.White) {
+		t.Errorf("Expected flattened image to contain merged pixel, got %v", got)
+	}
+}
+
+func Test_changeLayers_sharesPixels(t *testing.T) {
+	i, _ := createImage("2,2")
+	li := newLayeredImage(i)
+	h := newHistory()
+	do := func(cmd command) {
+		if cmd == nil {
+			t.Fatal("Expected a command")
+		}
+		h.push(cmd)
+	}
+
+	cmd := changeLayers(li, func(m *layeredImage) bool {
+		m.addLayer()
+		return true
+	})
+	do(cmd)
+	if cmd.(*layersCommand).before.layers[0].pixels != li.layers[0].pixels {
+		t.Error("Expected the unchanged layer to share its pixels with the snapshot")
+	}
+	red := color.NRGBA{255, 0, 0, 255}
+	do(paintPixel(li, image.Pt(0, 0), red))
+	do(rotateImage(li, true))
+	do(paintPixel(li, image.Pt(1, 1), color.White))
+	do(changeLayers(li, func(m *layeredImage) bool {
+		m.activeLayer().opacity = 0.5
+		return true
+	}))
+
+	for h.undo(li) {
+	}
+	if len(li.layers) != 1 || !li.layers[0].isEmpty() {
+		t.Fatalf("Expected undo to restore the empty image, got %d layers", len(li.layers))
+	}
+	for h.redo(li) {
+	}
+	l := li.layers[1]
+	if l.opacity != 0.5 || !sameColor(l.at(image.Pt(1, 0)), red) || !sameColor(l.at(image.Pt(1, 1)), color.White) || paintedPixels(l) != 2 {
+		t.Errorf("Expected redo to restore the rotated pixels, got %v %v", l.at(image.Pt(1, 0)), l.at(image.Pt(1, 1)))
+	}
+}
@@ -5,29 +5,56 @@ import (
 	"flag"
 	"fmt"
 	"image"
+	"io"
 	"log"
 	"os"
 	"strconv"
 	"strings"
-
-	"image/png"
-	_ "image/png"
 )
 
+// stdio is the file name for reading the image from stdin and writing it to
+// stdout.
+const stdio = "-"
+
 func main() {
-	fileName := flag.String("f", "", "Path for the file you want to open")
+	fileName := flag.String("f", "", "Path for the file you want to open, - reads the image from stdin and writes it to stdout")
 	res := flag.String("res", "", "Image height and width separated by a comma, e.g. 20,10 for a 20x10 image. Note that no spaces can be used.")
+	scale := flag.String("scale", "", "Scale the image and save it without the interactive editor: nearest:N, scale2x, scale3x or box:N")
+	sheet := flag.String("sheet", "", "Export the frames as a sprite sheet PNG with a JSON atlas next to it, without the interactive editor")
+	sheetOptions := flag.String("sheet-options", "", "Comma separated sprite sheet options: grid, strip, frames, layers and columns=N")
+	slice := flag.String("slice", "", "Slice the image into frames of WIDTHxHEIGHT pixels, e.g. 16x16")
+	format := flag.String("format", "", "Image format for saving instead of the file extension, and for loading data of unknown type: "+formatNames())
+	quality := flag.Int("quality", defaultFormatOptions.quality, "Quality of saved JPEG images from 1 to 100")
+	plain := flag.Bool("plain", false, "Save PBM, PGM and PPM images in the plain ASCII variant")
+	script := flag.String("script", "", "Path to a script with editing operations to run without the interactive editor, - reads the script from stdin")
 
 	flag.Parse()
 
+	if *format != "" {
+		if _, err := findFormat(*format); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *quality < 1 || *quality > 100 {
+		log.Fatalf("invalid quality %d, use 1 to 100", *quality)
+	}
+	saver := imageSaver{*format, formatOptions{quality: *quality, plain: *plain}}
+
 	var m image.Image
+	var p *project
 	var err error
 
-	isExistingFile := fileExists(*fileName)
+	isExistingFile := (*fileName != stdio && fileExists(*fileName)) || (*fileName == stdio && *res == "")
 
 	if *fileName != "" {
-		if isExistingFile {
-			m, err = loadImage(*fileName)
+		if isExistingFile && isProjectFile(*fileName) {
+			p, err = loadProject(*fileName)
+			if err != nil {
+				log.Fatal(err)
+			}
+			m = p.m
+		} else if isExistingFile {
+			m, err = loadImage(*fileName, *format)
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -37,7 +64,40 @@ func main() {
 				log.Fatal(err)
 			}
 		}
-		if err := NewCmdPxl(*fileName, m, saveImage).Run(); err != nil {
+		if m == nil {
+			log.Fatal("need to set either existing filename or resolution and new filename")
+		}
+		if *slice != "" {
+			if m, err = sliceImageFile(m, *slice); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *sheet != "" {
+			if err := exportSheet(*sheet, m, *sheetOptions, saver.save); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		if *scale != "" {
+			if err := scaleImageFile(*fileName, m, *scale, saver.save); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		if *script != "" {
+			if *script == stdio && *fileName == stdio {
+				log.Fatal("cannot read both the script and the image from stdin")
+			}
+			if err := runScript(*script, *fileName, m, saver.save); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		c := NewCmdPxl(*fileName, m, saver.save, nil)
+		if p != nil {
+			c.restoreProject(p)
+		}
+		if err := c.Run(); err != nil {
 			log.Fatal(err)
 		}
 	} else {
@@ -45,17 +105,75 @@ func main() {
 	}
 }
 
-func loadImage(fileName string) (image.Image, error) {
+// loadImage reads the image, see decodeImage.
+func loadImage(fileName, format string) (image.Image, error) {
+	if fileName == stdio {
+		return decodeImage(os.Stdin, format)
+	}
 	reader, err := os.Open(fileName)
 	if err != nil {
 		return nil, err
 	}
 	defer reader.Close()
-	m, _, err := image.Decode(reader)
+	return decodeImage(reader, format)
+}
+
+func runScript(scriptName, fileName string, m image.Image, saveImage saveImageCallback) error {
+	var r io.Reader = os.Stdin
+	if scriptName != stdio {
+		f, err := os.Open(scriptName)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	return newScriptRunner(fileName, m, saveImage).run(r)
+}
+
+// scaleImageFile scales the image and saves it, the same way as a script
+// would.
+func scaleImageFile(fileName string, m image.Image, spec string, saveImage saveImageCallback) error {
+	fn, err := parseScale(spec)
+	if err != nil {
+		return err
+	}
+	sr := newScriptRunner(fileName, m, saveImage)
+	sr.do(scaleImage(sr.m, fn))
+	return sr.save(fileName)
+}
+
+// sliceImageFile splits the sprite sheet into frames of the given cell size.
+func sliceImageFile(m image.Image, size string) (image.Image, error) {
+	w, h, err := parseCellSize(size)
 	if err != nil {
 		return nil, err
 	}
-	return m, nil
+	li, ok := m.(*layeredImage)
+	if !ok {
+		li = newLayeredImage(m)
+	}
+	if _, err := sliceImage(li, w, h); err != nil {
+		return nil, err
+	}
+	return li, nil
+}
+
+// exportSheet writes the sprite sheet and its atlas.
+func exportSheet(fileName string, m image.Image, options string, saveImage saveImageCallback) error {
+	var args []string
+	if options != "" {
+		args = strings.Split(options, ",")
+	}
+	opts, err := parseSheetOptions(args)
+	if err != nil {
+		return err
+	}
+	li, ok := m.(*layeredImage)
+	if !ok {
+		li = newLayeredImage(m)
+	}
+	return writeSheet(fileName, li, opts, saveImage)
 }
 
 func createImage(res string) (image.Image, error) {
@@ -74,12 +192,35 @@ func createImage(res string) (image.Image, error) {
 	return image.NewRGBA(image.Rectangle{image.Point{0, 0}, image.Point{w, h}}), nil
 }
 
-func saveImage(fileName string, m image.Image) error {
+// imageSaver saves images in the format of the file extension unless the
+// format is given explicitly.
+type imageSaver struct {
+	format string
+	opts   formatOptions
+}
+
+func (is imageSaver) save(fileName string, m image.Image) error {
+	f, err := formatForFile(fileName, is.format)
+	if err != nil {
+		return err
+	}
+	if fileName == stdio {
+		return f.encode(os.Stdout, m, is.opts)
+	}
 	outFile, err := os.Create(fileName)
 	if err != nil {
 		return err
 	}
-	return png.Encode(outFile, m)
+	if err := f.encode(outFile, m, is.opts); err != nil {
+		outFile.Close()
+		return err
+	}
+	return outFile.Close()
+}
+
+// saveImage saves the image in the format of the file extension.
+func saveImage(fileName string, m image.Image) error {
+	return imageSaver{opts: defaultFormatOptions}.save(fileName, m)
 }
 
 func fileExists(fileName string) bool {
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// menu is a modal list of items where a single item is selected.
+type menu struct {
+	title    string
+	items    []string
+	selected int
+}
+
+func newMenu(title string, items []string) *menu {
+	return &menu{
+		title:    title,
+		items:    items,
+		selected: 0,
+	}
+}
+
+func (mn *menu) move(dir direction) {
+	if dir == dirIncrease {
+		mn.selected = mod(mn.selected+1, len(mn.items))
+	} else {
+		mn.selected = mod(mn.selected-1, len(mn.items))
+	}
+}
+
+// draw renders the menu in a box at x, y with optional footer lines below the
+// items.
+func (mn *menu) draw(s tcell.Screen, x, y int, style tcell.Style, footer ...string) *drawBox {
+	width := utf8.RuneCountInString(mn.title)
+	for _, item := range mn.items {
+		width = max(width, utf8.RuneCountInString(item)+2)
+	}
+	for _, line := range footer {
+		width = max(width, utf8.RuneCountInString(line))
+	}
+	height := len(mn.items) + len(footer) + 1
+	dBox := newDrawBox(x, y, width+2+borderSize*2, height+borderSize*2).clear(s, style).draw(s, style)
+	p := dBox.getPoint(1, 0)
+	drawText(s, p.X, p.Y, style, mn.title)
+	for i, item := range mn.items {
+		prefix := "  "
+		itemStyle := style
+		if i == mn.selected {
+			prefix = "> "
+			itemStyle = style.Reverse(true)
+		}
+		drawText(s, p.X, p.Y+1+i, itemStyle, prefix+item)
+	}
+	for i, line := range footer {
+		drawText(s, p.X, p.Y+1+len(mn.items)+i, style, line)
+	}
+	return dBox
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"image"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// mouseState tracks an ongoing mouse drag between events.
+type mouseState struct {
+	buttons tcell.ButtonMask
+	last    image.Point
+	stroke  *pixelCommand
+	// painted is the last image point painted by the stroke
+	painted image.Point
+	shape   bool
+}
+
+func (c *CmdPxl) handleMouse(ev *tcell.EventMouse) {
+	x, y := ev.Position()
+	pos := image.Pt(x, y)
+	buttons := ev.Buttons()
+	pressed := buttons &^ c.mouse.buttons
+	defer func() {
+		c.mouse.buttons = buttons
+		c.mouse.last = pos
+	}()
+
+	switch {
+	case buttons&tcell.Button1 != 0:
+		if pressed&tcell.Button1 != 0 {
+			if c.selectSwatch(pos) {
+				return
+			}
+			if c.tool == toolPencil {
+				c.mouse.stroke = newPixelCommand()
+			} else if pt, ok := c.viewport().screenToImage(pos); ok {
+				c.cursor = pt
+				c.shape = &shape{tool: c.tool, from: pt, to: pt}
+				c.mouse.shape = true
+			}
+		}
+		pt, ok := c.viewport().screenToImage(pos)
+		if !ok {
+			return
+		}
+		switch {
+		case c.mouse.shape:
+			// any modifier constrains the shape
+			c.cursor = pt
+			c.updateShape(ev.Modifiers()&(tcell.ModShift|tcell.ModAlt|tcell.ModCtrl) != 0)
+		case c.mouse.stroke != nil:
+			c.cursor = pt
+			from := pt
+			if !c.mouse.stroke.empty() {
+				// fast drags skip pixels between the events
+				from = c.mouse.painted
+			}
+			plotLine(from, pt, func(p image.Point) {
+				c.mouse.stroke.set(c.m, p, c.penColor.c)
+			})
+			c.mouse.painted = pt
+		}
+	case buttons&(tcell.Button2|tcell.Button3) != 0:
+		if pressed == 0 {
+			mode := c.getRenderMode()
+			c.panBy(c.mouse.last.X/mode.cols-pos.X/mode.cols, c.mouse.last.Y*2/mode.halfRows-pos.Y*2/mode.halfRows)
+		}
+	default:
+		c.endMouseStroke()
+	}
+}
+
+// endMouseStroke commits the pixels painted or the shape drawn while
+// dragging as a single history item.
+func (c *CmdPxl) endMouseStroke() {
+	if c.mouse.stroke != nil && !c.mouse.stroke.empty() {
+		c.splitStroke()
+		c.history.push(c.mouse.stroke)
+	}
+	c.mouse.stroke = nil
+	if c.mouse.shape && c.shape != nil {
+		c.commitShape()
+	}
+	c.mouse.shape = false
+}
+
+// selectSwatch selects the palette swatch at the screen position if there is
+// one.
+func (c *CmdPxl) selectSwatch(pos image.Point) bool {
+	p := c.getColorSelectBox().getPoint(0, 1)
+	if pos.Y != p.Y {
+		return false
+	}
+	for section, selectFn := range []func(int){
+		c.penColor.selectHue,
+		c.penColor.selectSaturation,
+		c.penColor.selectValue,
+		c.penColor.selectAlpha,
+	} {
+		offset := pos.X - (p.X + section*sectionWidth)
+		if offset >= 0 && offset < c.penColor.paletteSize {
+			selectFn(offset)
+			return true
+		}
+	}
+	return false
+}
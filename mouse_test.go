@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func Test_CmdPxl_handleMouse(t *testing.T) {
+	i, _ := createImage("10,10")
+	c := NewCmdPxl("test.png", i, nil, nil)
+	c.imageBox = newDrawBox(0, 0, 22, 12)
+
+	c.handleMouse(tcell.NewEventMouse(1, 1, tcell.Button1, tcell.ModNone))
+	c.handleMouse(tcell.NewEventMouse(3, 1, tcell.Button1, tcell.ModNone))
+	c.handleMouse(tcell.NewEventMouse(3, 2, tcell.Button1, tcell.ModNone))
+	c.handleMouse(tcell.NewEventMouse(3, 2, tcell.ButtonNone, tcell.ModNone))
+
+	if len(c.history.undoStack) != 1 {
+		t.Fatalf("Expected a single history item for the stroke, got %d", len(c.history.undoStack))
+	}
+	if n := paintedPixels(c.m.layers[0]); n != 3 {
+		t.Errorf("Expected 3 painted pixels, got %d", n)
+	}
+	c.history.undo(c.m)
+	if n := paintedPixels(c.m.layers[0]); n != 0 {
+		t.Errorf("Expected stroke to be undone, got %d pixels", n)
+	}
+}
+
+func Test_CmdPxl_handleMouse_fastDrag(t *testing.T) {
+	i, _ := createImage("10,10")
+	c := NewCmdPxl("test.png", i, nil, nil)
+	c.imageBox = newDrawBox(0, 0, 22, 12)
+
+	c.handleMouse(tcell.NewEventMouse(1, 1, tcell.Button1, tcell.ModNone))
+	c.handleMouse(tcell.NewEventMouse(13, 1, tcell.Button1, tcell.ModNone))
+	c.handleMouse(tcell.NewEventMouse(13, 5, tcell.Button1, tcell.ModNone))
+	c.handleMouse(tcell.NewEventMouse(13, 5, tcell.ButtonNone, tcell.ModNone))
+
+	want := []string{
+		"#######...",
+		"......#...",
+		"......#...",
+		"......#...",
+		"......#...",
+	}
+	if got := strings.Join(strings.Split(pixelString(c.m), "\n")[:5], "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("Expected the drag to paint connected lines, got\n%s", got)
+	}
+	if len(c.history.undoStack) != 1 {
+		t.Errorf("Expected a single history item for the stroke, got %d", len(c.history.undoStack))
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Netpbm images start with the magic number P1 to P7. P1 to P3 are the plain
+// variants with ASCII samples of P4 to P6, P7 is PAM which can have alpha.
+const (
+	netpbmMaxLine = 70
+	pamMaxval     = 255
+	// netpbmMaxSamples limits the size of decoded images, the header alone
+	// could otherwise request any amount of memory
+	netpbmMaxSamples = 1 << 26
+)
+
+// netpbmHeader describes the raster of a Netpbm image.
+type netpbmHeader struct {
+	magic         string
+	width, height int
+	// depth is the number of samples per pixel
+	depth  int
+	maxval int
+	// tupleType is the PAM tuple type, the other formats get the matching one
+	tupleType string
+}
+
+func init() {
+	for magic, name := range map[string]string{"P1": "pbm", "P2": "pgm", "P3": "ppm", "P4": "pbm", "P5": "pgm", "P6": "ppm", "P7": "pam"} {
+		image.RegisterFormat(name, magic, decodeNetpbm, decodeNetpbmConfig)
+	}
+}
+
+// netpbmReader reads the whitespace separated tokens of the headers and plain
+// rasters, comments start with # and end at the line end.
+type netpbmReader struct {
+	*bufio.Reader
+}
+
+// token returns the next token and consumes the single whitespace after it.
+func (r netpbmReader) token() (string, error) {
+	var sb strings.Builder
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF && sb.Len() > 0 {
+			return sb.String(), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case b == '#' && sb.Len() == 0:
+			if _, err := r.ReadString('\n'); err != nil {
+				return "", err
+			}
+		case isNetpbmSpace(b):
+			if sb.Len() > 0 {
+				return sb.String(), nil
+			}
+		default:
+			sb.WriteByte(b)
+		}
+	}
+}
+
+func (r netpbmReader) number() (int, error) {
+	t, err := r.token()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(t)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("netpbm: invalid number %q", t)
+	}
+	return n, nil
+}
+
+// bit returns the next sample of a plain PBM raster, the samples do not
+// need to be separated.
+func (r netpbmReader) bit() (int, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case b == '0' || b == '1':
+			return int(b - '0'), nil
+		case b == '#':
+			if _, err := r.ReadString('\n'); err != nil {
+				return 0, err
+			}
+		case !isNetpbmSpace(b):
+			return 0, fmt.Errorf("netpbm: invalid bit %q", b)
+		}
+	}
+}
+
+func isNetpbmSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f'
+}
+
+func (r netpbmReader) header() (netpbmHeader, error) {
+	h := netpbmHeader{depth: 1, maxval: 1}
+	var err error
+	if h.magic, err = r.token(); err != nil {
+		return h, err
+	}
+	switch h.magic {
+	case "P1", "P4":
+		h.tupleType = "BLACKANDWHITE"
+	case "P2", "P5":
+		h.tupleType = "GRAYSCALE"
+	case "P3", "P6":
+		h.tupleType, h.depth = "RGB", 3
+	case "P7":
+		return r.pamHeader(h)
+	default:
+		return h, fmt.Errorf("netpbm: unknown magic number %q", h.magic)
+	}
+	if h.width, err = r.number(); err != nil {
+		return h, err
+	}
+	if h.height, err = r.number(); err != nil {
+		return h, err
+	}
+	if h.tupleType != "BLACKANDWHITE" {
+		if h.maxval, err = r.number(); err != nil {
+			return h, err
+		}
+	}
+	return h, h.validate()
+}
+
+// pamHeader reads the lines of the PAM header up to ENDHDR.
+func (r netpbmReader) pamHeader(h netpbmHeader) (netpbmHeader, error) {
+	h.depth, h.maxval = 0, 0
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return h, fmt.Errorf("netpbm: incomplete PAM header: %w", err)
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] == "ENDHDR" {
+			break
+		}
+		if len(fields) < 2 {
+			return h, fmt.Errorf("netpbm: invalid PAM header line %q", strings.TrimSpace(line))
+		}
+		if fields[0] == "TUPLTYPE" {
+			h.tupleType = strings.Join(fields[1:], " ")
+			continue
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return h, fmt.Errorf("netpbm: invalid PAM header line %q", strings.TrimSpace(line))
+		}
+		switch fields[0] {
+		case "WIDTH":
+			h.width = n
+		case "HEIGHT":
+			h.height = n
+		case "DEPTH":
+			h.depth = n
+		case "MAXVAL":
+			h.maxval = n
+		}
+	}
+	return h, h.validate()
+}
+
+func (h netpbmHeader) validate() error {
+	if h.width < 1 || h.height < 1 {
+		return fmt.Errorf("netpbm: invalid size %dx%d", h.width, h.height)
+	}
+	if h.maxval < 1 || h.maxval > 0xffff {
+		return fmt.Errorf("netpbm: invalid maxval %d", h.maxval)
+	}
+	if h.depth < 1 || h.depth > 4 {
+		return fmt.Errorf("netpbm: unsupported depth %d", h.depth)
+	}
+	// divide instead of multiplying to avoid overflows
+	if h.width > netpbmMaxSamples/h.height/h.depth {
+		return fmt.Errorf("netpbm: image of %dx%d pixels is too large", h.width, h.height)
+	}
+	return nil
+}
+
+func decodeNetpbmConfig(r io.Reader) (image.Config, error) {
+	h, err := netpbmReader{bufio.NewReader(r)}.header()
+	if err != nil {
+		return image.Config{}, err
+	}
+	model := color.NRGBAModel
+	if h.maxval > 0xff {
+		model = color.NRGBA64Model
+	}
+	return image.Config{ColorModel: model, Width: h.width, Height: h.height}, nil
+}
+
+// decodeNetpbm reads all Netpbm variants, the samples are scaled from maxval
+// to the full range. The image grows row by row, so truncated data does not
+// allocate the size announced in the header.
+func decodeNetpbm(r io.Reader) (image.Image, error) {
+	nr := netpbmReader{bufio.NewReader(r)}
+	h, err := nr.header()
+	if err != nil {
+		return nil, err
+	}
+	bytesPerPixel := 4
+	if h.maxval > 0xff {
+		bytesPerPixel = 8
+	}
+	row := make([]byte, h.width*bytesPerPixel)
+	var pix []byte
+	sample := nr.sampleReader(h)
+	samples := make([]int, h.depth)
+	for y := 0; y < h.height; y++ {
+		for x := 0; x < h.width; x++ {
+			for i := range samples {
+				if samples[i], err = sample(); err != nil {
+					return nil, fmt.Errorf("netpbm: truncated raster: %w", err)
+				}
+				if samples[i] > h.maxval {
+					return nil, fmt.Errorf("netpbm: sample %d is larger than maxval %d", samples[i], h.maxval)
+				}
+			}
+			c := h.color(samples)
+			channels := []uint16{c.R, c.G, c.B, c.A}
+			for i, v := range channels {
+				if bytesPerPixel == 4 {
+					row[x*4+i] = uint8(v >> 8)
+				} else {
+					row[x*8+i*2], row[x*8+i*2+1] = uint8(v>>8), uint8(v)
+				}
+			}
+		}
+		pix = append(pix, row...)
+		if h.magic == "P4" {
+			// rows of binary bitmaps are padded to full bytes
+			sample = nr.sampleReader(h)
+		}
+	}
+	b := image.Rect(0, 0, h.width, h.height)
+	if bytesPerPixel == 8 {
+		return &image.NRGBA64{Pix: pix, Stride: len(row), Rect: b}, nil
+	}
+	return &image.NRGBA{Pix: pix, Stride: len(row), Rect: b}, nil
+}
+
+// sampleReader returns a function reading the next sample of the raster.
+func (r netpbmReader) sampleReader(h netpbmHeader) func() (int, error) {
+	switch h.magic {
+	case "P1":
+		return r.bit
+	case "P2", "P3":
+		return r.number
+	case "P4":
+		var bits byte
+		n := 0
+		return func() (int, error) {
+			if n == 0 {
+				var err error
+				if bits, err = r.ReadByte(); err != nil {
+					return 0, err
+				}
+				n = 8
+			}
+			n--
+			return int(bits>>n) & 1, nil
+		}
+	}
+	if h.maxval > 0xff {
+		return func() (int, error) {
+			var buf [2]byte
+			if _, err := io.ReadFull(r, buf[:]); err != nil {
+				return 0, err
+			}
+			return int(buf[0])<<8 | int(buf[1]), nil
+		}
+	}
+	return func() (int, error) {
+		b, err := r.ReadByte()
+		return int(b), err
+	}
+}
+
+// color converts the samples of a pixel, PBM uses 1 for black while PAM uses
+// 1 for white.
+func (h netpbmHeader) color(samples []int) color.NRGBA64 {
+	scale := func(s int) uint16 {
+		return uint16(s * 0xffff / h.maxval)
+	}
+	if h.magic == "P1" || h.magic == "P4" {
+		v := uint16(0xffff) * uint16(1-samples[0])
+		return color.NRGBA64{v, v, v, 0xffff}
+	}
+	switch len(samples) {
+	case 1:
+		v := scale(samples[0])
+		return color.NRGBA64{v, v, v, 0xffff}
+	case 2:
+		v := scale(samples[0])
+		return color.NRGBA64{v, v, v, scale(samples[1])}
+	case 3:
+		return color.NRGBA64{scale(samples[0]), scale(samples[1]), scale(samples[2]), 0xffff}
+	}
+	return color.NRGBA64{scale(samples[0]), scale(samples[1]), scale(samples[2]), scale(samples[3])}
+}
+
+// netpbmWriter writes the samples of plain rasters in lines of at most 70
+// characters, binary rasters are written as bytes.
+type netpbmWriter struct {
+	*bufio.Writer
+	plain bool
+	line  int
+}
+
+func (w *netpbmWriter) sample(s int) {
+	if !w.plain {
+		w.WriteByte(byte(s))
+		return
+	}
+	t := strconv.Itoa(s)
+	if w.line > 0 && w.line+1+len(t) > netpbmMaxLine {
+		w.WriteByte('\n')
+		w.line = 0
+	}
+	if w.line > 0 {
+		w.WriteByte(' ')
+		w.line++
+	}
+	w.WriteString(t)
+	w.line += len(t)
+}
+
+func (w *netpbmWriter) endRow() {
+	if w.plain {
+		w.WriteByte('\n')
+		w.line = 0
+	}
+}
+
+// encodeNetpbm writes PBM, PGM or PPM images, the pixels are drawn on a
+// white background.
+func encodeNetpbm(w io.Writer, m image.Image, magic string, opts formatOptions) error {
+	if li, ok := m.(*layeredImage); ok {
+		m = li.export()
+	}
+	rgb := onWhite(m)
+	b := rgb.Rect
+	nw := &netpbmWriter{Writer: bufio.NewWriter(w), plain: opts.plain}
+	if opts.plain {
+		// the plain variants have the magic numbers P1 to P3
+		magic = "P" + string(magic[1]-3)
+	}
+	fmt.Fprintf(nw, "%s\n%d %d\n", magic, b.Dx(), b.Dy())
+	if magic != "P1" && magic != "P4" {
+		fmt.Fprintf(nw, "%d\n", 0xff)
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var bits, n byte
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := rgb.RGBAAt(x, y)
+			switch magic {
+			case "P1", "P4":
+				bit := byte(0)
+				if color.GrayModel.Convert(c).(color.Gray).Y < 0x80 {
+					bit = 1
+				}
+				if magic == "P1" {
+					nw.sample(int(bit))
+					continue
+				}
+				bits, n = bits<<1|bit, n+1
+				if n == 8 {
+					nw.WriteByte(bits)
+					bits, n = 0, 0
+				}
+			case "P2", "P5":
+				nw.sample(int(color.GrayModel.Convert(c).(color.Gray).Y))
+			default:
+				nw.sample(int(c.R))
+				nw.sample(int(c.G))
+				nw.sample(int(c.B))
+			}
+		}
+		if n > 0 {
+			nw.WriteByte(bits << (8 - n))
+		}
+		nw.endRow()
+	}
+	return nw.Flush()
+}
+
+// encodePAM writes the image with alpha, PAM has no plain variant.
+func encodePAM(w io.Writer, m image.Image, opts formatOptions) error {
+	if opts.plain {
+		return errors.New("netpbm: PAM has no plain variant")
+	}
+	if li, ok := m.(*layeredImage); ok {
+		m = li.export()
+	}
+	b := m.Bounds()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "P7\nWIDTH %d\nHEIGHT %d\nDEPTH 4\nMAXVAL %d\nTUPLTYPE RGB_ALPHA\nENDHDR\n", b.Dx(), b.Dy(), pamMaxval)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := toNRGBA(m.At(x, y))
+			bw.Write([]byte{c.R, c.G, c.B, c.A})
+		}
+	}
+	return bw.Flush()
+}
+
+func encodePBM(w io.Writer, m image.Image, opts formatOptions) error {
+	return encodeNetpbm(w, m, "P4", opts)
+}
+
+func encodePGM(w io.Writer, m image.Image, opts formatOptions) error {
+	return encodeNetpbm(w, m, "P5", opts)
+}
+
+func encodePPM(w io.Writer, m image.Image, opts formatOptions) error {
+	return encodeNetpbm(w, m, "P6", opts)
+}
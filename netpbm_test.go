@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func Test_decodeNetpbm(t *testing.T) {
+	black, white := color.NRGBA{0, 0, 0, 255}, color.NRGBA{255, 255, 255, 255}
+	tests := []struct {
+		name string
+		data string
+		want []color.NRGBA
+	}{
+		{"plain bitmap", "P1\n# comment\n3 1\n1 0 1\n", []color.NRGBA{black, white, black}},
+		{"plain bitmap without spaces", "P1 3 1 101", []color.NRGBA{black, white, black}},
+		{"plain graymap", "P2 2 1 4\n0 2\n", []color.NRGBA{black, {127, 127, 127, 255}}},
+		{"plain pixmap", "P3 1 1 255\n255 0 128\n", []color.NRGBA{{255, 0, 128, 255}}},
+		{"bitmap with padded rows", "P4 3 2\n\xa0\x40", []color.NRGBA{black, white, black, white, black, white}},
+		{"graymap", "P5 2 1 255\n\x00\xff", []color.NRGBA{black, white}},
+		{"16 bit graymap", "P5 2 1 65535\n\x00\x00\xff\xff", []color.NRGBA{black, white}},
+		{"pixmap", "P6 1 1 255\n\x01\x02\x03", []color.NRGBA{{1, 2, 3, 255}}},
+		{"pam with alpha", "P7\nWIDTH 1\nHEIGHT 1\nDEPTH 4\nMAXVAL 255\nTUPLTYPE RGB_ALPHA\nENDHDR\n\x01\x02\x03\x80", []color.NRGBA{{1, 2, 3, 128}}},
+		{"pam gray with alpha", "P7\n# comment\nWIDTH 1\nHEIGHT 1\nDEPTH 2\nMAXVAL 255\nTUPLTYPE GRAYSCALE_ALPHA\nENDHDR\n\x40\x80", []color.NRGBA{{64, 64, 64, 128}}},
+		{"pam black and white", "P7\nWIDTH 2\nHEIGHT 1\nDEPTH 1\nMAXVAL 1\nTUPLTYPE BLACKANDWHITE\nENDHDR\n\x00\x01", []color.NRGBA{black, white}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, format, err := image.Decode(strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := findFormat(format); err != nil {
+				t.Errorf("Unexpected format %s", format)
+			}
+			b := m.Bounds()
+			for i, want := range tt.want {
+				got := toNRGBA(m.At(i%b.Dx(), i/b.Dx()))
+				if got != want {
+					t.Errorf("pixel %d = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_decodeNetpbm_errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{"invalid size", "P2 0 1 255\n", "invalid size 0x1"},
+		{"invalid maxval", "P5 1 1 70000\n", "invalid maxval 70000"},
+		{"truncated", "P6 2 2 255\n\x00\x00\x00", "truncated raster"},
+		{"sample over maxval", "P2 1 1 3\n4\n", "sample 4 is larger than maxval 3"},
+		{"incomplete pam header", "P7\nWIDTH 1\n", "incomplete PAM header"},
+		{"too large", "P6\n100000 100000\n255\n", "too large"},
+		{"huge", "P6 4000000000 4000000000 255", "too large"},
+		{"too deep", "P7\nWIDTH 8192\nHEIGHT 8192\nDEPTH 4\nMAXVAL 255\nENDHDR\n", "too large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeNetpbm(strings.NewReader(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("decodeNetpbm() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_encodeNetpbm(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 9, 1))
+	m.Set(0, 0, color.Black)
+	m.Set(8, 0, color.NRGBA{255, 0, 0, 128})
+	tests := []struct {
+		name       string
+		encode     func(w *bytes.Buffer, m image.Image, opts formatOptions) error
+		plain      bool
+		wantPrefix string
+		// want is the expected color of the last pixel
+		want color.NRGBA
+	}{
+		{"bitmap", func(w *bytes.Buffer, m image.Image, o formatOptions) error { return encodePBM(w, m, o) }, false, "P4\n9 1\n\x80\x00", color.NRGBA{255, 255, 255, 255}},
+		{"plain bitmap", func(w *bytes.Buffer, m image.Image, o formatOptions) error { return encodePBM(w, m, o) }, true, "P1\n9 1\n1 0 0", color.NRGBA{255, 255, 255, 255}},
+		{"plain graymap", func(w *bytes.Buffer, m image.Image, o formatOptions) error { return encodePGM(w, m, o) }, true, "P2\n9 1\n255\n0 255", color.NRGBA{165, 165, 165, 255}},
+		{"pixmap", func(w *bytes.Buffer, m image.Image, o formatOptions) error { return encodePPM(w, m, o) }, false, "P6\n9 1\n255\n", color.NRGBA{255, 127, 127, 255}},
+		{"pam", func(w *bytes.Buffer, m image.Image, o formatOptions) error { return encodePAM(w, m, o) }, false, "P7\nWIDTH 9\n", color.NRGBA{255, 0, 0, 128}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.encode(&buf, m, formatOptions{plain: tt.plain}); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(buf.String(), tt.wantPrefix) {
+				t.Errorf("Expected the data to start with %q, got %q", tt.wantPrefix, buf.String())
+			}
+			got, err := decodeNetpbm(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c := toNRGBA(got.At(8, 0)); c != tt.want {
+				t.Errorf("Expected the last pixel to be %v, got %v", tt.want, c)
+			}
+		})
+	}
+	if err := encodePAM(&bytes.Buffer{}, m, formatOptions{plain: true}); err == nil {
+		t.Errorf("Expected an error for plain PAM")
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+const (
+	// maxPaletteSize is the number of colors an indexed PNG or GIF can hold.
+	maxPaletteSize = 256
+	// paletteColumns is the number of swatches in a row of the palette grid.
+	paletteColumns = 16
+	swatchWidth    = 2
+)
+
+// quantize returns the colors used by the images in the order of their first
+// appearance. Images with too many colors are reduced with median cut.
+func quantize(images ...image.Image) color.Palette {
+	counts := map[color.NRGBA]int{}
+	result := color.Palette{}
+	for _, m := range images {
+		b := m.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				n := toNRGBA(m.At(x, y))
+				if n.A == 0 {
+					// all transparent pixels share a single entry
+					n = color.NRGBA{}
+				}
+				if counts[n] == 0 {
+					result = append(result, n)
+				}
+				counts[n]++
+			}
+		}
+	}
+	if len(result) <= maxPaletteSize {
+		return result
+	}
+	size := maxPaletteSize
+	reduced := color.Palette{}
+	if counts[color.NRGBA{}] > 0 {
+		delete(counts, color.NRGBA{})
+		reduced = append(reduced, color.NRGBA{})
+		size--
+	}
+	return append(reduced, medianCut(counts, size)...)
+}
+
+// colorBox is a set of colors of the median cut algorithm.
+type colorBox []color.NRGBA
+
+// channel returns the channel with the largest range and its range.
+func (cb colorBox) channel() (func(color.NRGBA) uint8, int) {
+	channels := []func(color.NRGBA) uint8{
+		func(c color.NRGBA) uint8 { return c.R },
+		func(c color.NRGBA) uint8 { return c.G },
+		func(c color.NRGBA) uint8 { return c.B },
+		func(c color.NRGBA) uint8 { return c.A },
+	}
+	best, bestRange := channels[0], -1
+	for _, ch := range channels {
+		lo, hi := 255, 0
+		for _, c := range cb {
+			lo, hi = min(lo, int(ch(c))), max(hi, int(ch(c)))
+		}
+		if hi-lo > bestRange {
+			best, bestRange = ch, hi-lo
+		}
+	}
+	return best, bestRange
+}
+
+// medianCut reduces the colors, weighted by the number of pixels, to at most
+// size colors by splitting the box with the largest range at its median.
+func medianCut(counts map[color.NRGBA]int, size int) color.Palette {
+	all := make(colorBox, 0, len(counts))
+	for c := range counts {
+		all = append(all, c)
+	}
+	// sort for reproducible palettes
+	key := func(c color.NRGBA) uint32 {
+		return uint32(c.R)<<24 | uint32(c.G)<<16 | uint32(c.B)<<8 | uint32(c.A)
+	}
+	sort.Slice(all, func(i, j int) bool { return key(all[i]) < key(all[j]) })
+	boxes := []colorBox{all}
+	for len(boxes) < size {
+		widest, widestRange := -1, 0
+		for i, cb := range boxes {
+			if _, r := cb.channel(); len(cb) > 1 && r > widestRange {
+				widest, widestRange = i, r
+			}
+		}
+		if widest < 0 {
+			break
+		}
+		cb := boxes[widest]
+		ch, _ := cb.channel()
+		sort.SliceStable(cb, func(i, j int) bool { return ch(cb[i]) < ch(cb[j]) })
+		total := 0
+		for _, c := range cb {
+			total += counts[c]
+		}
+		split, seen := 1, 0
+		for i, c := range cb[:len(cb)-1] {
+			seen += counts[c]
+			if seen*2 >= total {
+				split = i + 1
+				break
+			}
+		}
+		boxes[widest] = cb[:split]
+		boxes = append(boxes, cb[split:])
+	}
+	result := make(color.Palette, len(boxes))
+	for i, cb := range boxes {
+		var r, g, b, a, n int
+		for _, c := range cb {
+			w := counts[c]
+			r, g, b, a, n = r+int(c.R)*w, g+int(c.G)*w, b+int(c.B)*w, a+int(c.A)*w, n+w
+		}
+		result[i] = color.NRGBA{uint8((r + n/2) / n), uint8((g + n/2) / n), uint8((b + n/2) / n), uint8((a + n/2) / n)}
+	}
+	return result
+}
+
+// indexColors converts the image to indexed colors, every pixel is replaced
+// with the closest palette entry. The palette is shared by all frames.
+func indexColors(m *layeredImage) command {
+	if m.palette != nil {
+		return nil
+	}
+	frames := make([]image.Image, m.frameCount())
+	for i := range frames {
+		frames[i] = m.flattenFrame(i)
+	}
+	pal := quantize(frames...)
+	return changeLayers(m, func(m *layeredImage) bool {
+		m.palette = pal
+		for _, l := range m.allLayers() {
+			l.recolor(func(c color.NRGBA) color.Color {
+				return pal.Convert(c)
+			})
+		}
+		return true
+	})
+}
+
+// findColor returns the index of the palette entry with exactly the color or
+// -1.
+func findColor(pal color.Palette, c color.Color) int {
+	for i, pc := range pal {
+		if sameColor(pc, c) {
+			return i
+		}
+	}
+	return -1
+}
+
+// setPaletteColor changes a palette entry and recolors every pixel painted
+// with it. The color must not be used by another entry.
+func setPaletteColor(m *layeredImage, index int, c color.Color) command {
+	if index < 0 || index >= len(m.palette) || findColor(m.palette, c) >= 0 {
+		return nil
+	}
+	old := toNRGBA(m.palette[index])
+	return changeLayers(m, func(m *layeredImage) bool {
+		m.palette[index] = c
+		for _, l := range m.allLayers() {
+			l.recolor(func(pc color.NRGBA) color.Color {
+				if pc == old {
+					return c
+				}
+				return pc
+			})
+		}
+		return true
+	})
+}
+
+// addPaletteColor appends the color to the palette if there is room left and
+// it is not in the palette yet.
+func addPaletteColor(m *layeredImage, c color.Color) command {
+	if m.palette == nil || len(m.palette) >= maxPaletteSize || findColor(m.palette, c) >= 0 {
+		return nil
+	}
+	return changeLayers(m, func(m *layeredImage) bool {
+		m.palette = append(m.palette, c)
+		return true
+	})
+}
+
+// openPalette shows the palette grid, images with free colors are converted
+// to indexed colors first.
+func (c *CmdPxl) openPalette() {
+	if c.m.palette == nil {
+		c.do(indexColors(c.m))
+		c.message = fmt.Sprintf("converted to %d indexed colors", len(c.m.palette))
+	}
+	c.paletteIndex = c.m.palette.Index(c.penColor.c)
+	c.currentState = statePalette
+}
+
+func (c *CmdPxl) closePalette() {
+	c.currentState = stateDrawing
+	c.s.Clear()
+}
+
+func (c *CmdPxl) handlePaletteKey(ev *tcell.EventKey) {
+	move := func(d int) {
+		c.paletteIndex = min(max(c.paletteIndex+d, 0), len(c.m.palette)-1)
+	}
+	switch {
+	case ev.Key() == tcell.KeyEscape || ev.Rune() == 'P' || ev.Rune() == 'x':
+		c.closePalette()
+	case ev.Key() == tcell.KeyEnter || ev.Rune() == 'e' || ev.Rune() == ' ':
+		c.penColor = *NewCmdColor(c.m.palette[c.paletteIndex], c.paletteSize)
+		c.closePalette()
+	case ev.Key() == tcell.KeyUp || ev.Rune() == 'w':
+		move(-paletteColumns)
+	case ev.Key() == tcell.KeyDown || ev.Rune() == 's':
+		move(paletteColumns)
+	case ev.Key() == tcell.KeyLeft || ev.Rune() == 'a':
+		move(-1)
+	case ev.Key() == tcell.KeyRight || ev.Rune() == 'd':
+		move(1)
+	case ev.Rune() == 'r':
+		if i := findColor(c.m.palette, c.penColor.c); i >= 0 && i != c.paletteIndex {
+			c.message = fmt.Sprintf("the color is already entry #%d", i)
+		} else {
+			c.do(setPaletteColor(c.m, c.paletteIndex, c.penColor.c))
+		}
+	case ev.Rune() == 'n':
+		if i := findColor(c.m.palette, c.penColor.c); i >= 0 {
+			c.message = fmt.Sprintf("the color is already entry #%d", i)
+			c.paletteIndex = i
+		} else if cmd := addPaletteColor(c.m, c.penColor.c); cmd != nil {
+			c.do(cmd)
+			c.paletteIndex = len(c.m.palette) - 1
+		} else {
+			c.message = fmt.Sprintf("the palette is limited to %d colors", maxPaletteSize)
+		}
+	case ev.Rune() == 'z':
+		c.history.undo(c.m)
+		c.s.Clear()
+	case ev.Rune() == 'y':
+		c.history.redo(c.m)
+		c.s.Clear()
+	}
+	if c.currentState == statePalette {
+		if c.m.palette == nil {
+			// the conversion to indexed colors was undone
+			c.closePalette()
+			return
+		}
+		move(0)
+	}
+}
+
+// drawPalettePanel draws the palette as a grid of swatches.
+func (c *CmdPxl) drawPalettePanel() *drawBox {
+	footer := []string{
+		fmt.Sprintf("#%d %s", c.paletteIndex, encodeColor(c.m.palette[c.paletteIndex])),
+		"[wasd] select [e] use color",
+		"[r] set to pen [n] add pen",
+		"[z/y] undo/redo [esc] close",
+	}
+	rows := (len(c.m.palette) + paletteColumns - 1) / paletteColumns
+	width := paletteColumns * swatchWidth
+	for _, line := range footer {
+		width = max(width, len(line))
+	}
+	height := 1 + rows + len(footer)
+	dBox := newDrawBox(0, 0, width+2+borderSize*2, height+borderSize*2).clear(c.s, c.interfaceStyle).draw(c.s, c.interfaceStyle)
+	p := dBox.getPoint(1, 0)
+	drawText(c.s, p.X, p.Y, c.interfaceStyle, fmt.Sprintf("Palette (%d colors)", len(c.m.palette)))
+	for i, pc := range c.m.palette {
+		col, row := i%paletteColumns, i/paletteColumns
+		for j := 0; j < swatchWidth; j++ {
+			x := col*swatchWidth + j
+			cl := displayColor(image.Pt(x, row), pc)
+			style := tcell.StyleDefault.Background(tcell.FromImageColor(cl))
+			r := ' '
+			if i == c.paletteIndex {
+				style = style.Foreground(tcell.FromImageColor(getFgColor(cl)))
+				r = []rune("[]")[j]
+			}
+			c.s.SetContent(p.X+x, p.Y+1+row, r, nil, style)
+		}
+	}
+	for i, line := range footer {
+		drawText(c.s, p.X, p.Y+1+rows+i, c.interfaceStyle, line)
+	}
+	return dBox
+}
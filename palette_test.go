@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func Test_quantize(t *testing.T) {
+	m := textImage(
+		"#.#",
+		"..#",
+	)
+	m.Set(1, 1, color.Transparent)
+	got := quantize(m)
+	want := color.Palette{
+		color.NRGBA{0, 0, 0, 255},
+		color.NRGBA{255, 255, 255, 255},
+		color.NRGBA{},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("quantize() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if !sameColor(got[i], want[i]) {
+			t.Errorf("quantize()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	gradient := image.NewNRGBA(image.Rect(0, 0, 300, 1))
+	for x := 0; x < 300; x++ {
+		gradient.Set(x, 0, color.NRGBA{uint8(x), uint8(x / 256), 0, 255})
+	}
+	if got := quantize(gradient); len(got) > maxPaletteSize {
+		t.Errorf("Expected at most %d colors, got %d", maxPaletteSize, len(got))
+	}
+}
+
+func Test_setPaletteColor(t *testing.T) {
+	pal := color.Palette{color.NRGBA{0, 0, 0, 255}, color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}}
+	src := image.NewPaletted(image.Rect(0, 0, 3, 1), pal)
+	src.Pix = []uint8{1, 0, 1}
+	m := newLayeredImage(src)
+
+	green := color.NRGBA{0, 255, 0, 255}
+	cmd := setPaletteColor(m, 1, green)
+	if cmd == nil {
+		t.Fatal("Expected a command")
+	}
+	if !sameColor(m.At(0, 0), green) || !sameColor(m.At(2, 0), green) || !sameColor(m.At(1, 0), pal[0]) {
+		t.Errorf("Expected the pixels of the entry to be recolored, got %s", pixelString(m))
+	}
+	if setPaletteColor(m, 1, green) != nil {
+		t.Error("Expected no command for an unchanged entry")
+	}
+	if setPaletteColor(m, 1, pal[0]) != nil {
+		t.Error("Expected no command for the color of another entry")
+	}
+	if addPaletteColor(m, pal[2]) != nil {
+		t.Error("Expected no command for a color in the palette")
+	}
+
+	// the saved image keeps the indices
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, m.export()); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, ok := saved.(*image.Paletted)
+	if !ok {
+		t.Fatalf("Expected a paletted PNG, got %T", saved)
+	}
+	if !bytes.Equal(pm.Pix, src.Pix) || !sameColor(pm.Palette[1], green) || len(pm.Palette) != len(pal) {
+		t.Errorf("Expected indices %v with the recolored entry, got %v %v", src.Pix, pm.Pix, pm.Palette)
+	}
+
+	cmd.undo(m)
+	if !sameColor(m.palette[1], pal[1]) || !sameColor(m.At(0, 0), pal[1]) {
+		t.Errorf("Expected undo to restore the entry, got %v", m.palette[1])
+	}
+}
+
+func Test_indexColors(t *testing.T) {
+	m := newLayeredImage(textImage("#."))
+	m.addFrame(false)
+	red := color.NRGBA{255, 0, 0, 255}
+	m.Set(image.Pt(0, 0), red)
+	m.selectFrame(0)
+
+	cmd := indexColors(m)
+	if cmd == nil {
+		t.Fatal("Expected a command")
+	}
+	if len(m.palette) != 4 {
+		t.Fatalf("Expected the colors of both frames in the palette, got %v", m.palette)
+	}
+	m.selectFrame(1)
+	if !sameColor(m.At(0, 0), red) {
+		t.Errorf("Expected the second frame to keep its color, got %v", m.At(0, 0))
+	}
+	cmd.undo(m)
+	if m.palette != nil {
+		t.Errorf("Expected undo to restore free colors, got %v", m.palette)
+	}
+}
+
+func Test_layeredImage_paletteIndex(t *testing.T) {
+	black, white := color.NRGBA{0, 0, 0, 255}, color.NRGBA{255, 255, 255, 255}
+	src := image.NewPaletted(image.Rect(0, 0, 4, 1), color.Palette{black, white, black})
+	src.Pix = []uint8{0, 1, 2, 2}
+	m := newLayeredImage(src)
+	if len(m.palette) != 3 {
+		t.Fatalf("Expected the repeated entry to be kept, got %v", m.palette)
+	}
+	m.Set(image.Pt(1, 0), black)
+	m.Set(image.Pt(3, 0), white)
+	pm := m.export().(*image.Paletted)
+	if want := []uint8{0, 0, 2, 1}; !bytes.Equal(pm.Pix, want) || len(pm.Palette) != 3 {
+		t.Errorf("Expected indices %v with 3 entries, got %v %v", want, pm.Pix, pm.Palette)
+	}
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	projectExtension = ".cpxl"
+	projectManifest  = "project.json"
+	// projectVersion is the version of the project files written by this
+	// version of cmdpxl. Readers can open any project which does not require a
+	// newer version than this.
+	projectVersion = 1
+	// projectMinVersion is the oldest reader version which can open the
+	// project files written by this version.
+	projectMinVersion = 1
+)
+
+// project is the editor state which is stored in a project file.
+type project struct {
+	m           *layeredImage
+	penColor    color.Color
+	paletteSize int
+	fill        fillOptions
+	cursor      image.Point
+	pan         image.Point
+	history     *history
+}
+
+type projectJSON struct {
+	Version int `json:"version"`
+	// MinVersion is the oldest reader version which can open the file
+	MinVersion  int           `json:"minVersion"`
+	Image       snapshotJSON  `json:"image"`
+	PenColor    string        `json:"penColor"`
+	PaletteSize int           `json:"paletteSize"`
+	Fill        fillJSON      `json:"fill"`
+	Cursor      pointJSON     `json:"cursor"`
+	Pan         pointJSON     `json:"pan"`
+	Undo        []commandJSON `json:"undo"`
+	Redo        []commandJSON `json:"redo"`
+}
+
+type pointJSON struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type fillJSON struct {
+	Tolerance float64 `json:"tolerance"`
+	Space     string  `json:"space"`
+	Diagonal  bool    `json:"diagonal"`
+}
+
+// snapshotJSON describes a layered image, the layer pixels are stored as
+// separate PNG files.
+type snapshotJSON struct {
+	Min     pointJSON   `json:"min"`
+	Max     pointJSON   `json:"max"`
+	Current int         `json:"current"`
+	Layers  []layerJSON `json:"layers"`
+	// Palette holds the colors of indexed images
+	Palette []string `json:"palette,omitempty"`
+	// Frames holds all frames of animations, Layers and Current are the
+	// ones of the frame at index Frame.
+	Frames []frameJSON `json:"frames,omitempty"`
+	Frame  int         `json:"frame,omitempty"`
+	// LoopCount is the number of repetitions of animations as in GIF files
+	LoopCount int `json:"loopCount,omitempty"`
+}
+
+type frameJSON struct {
+	Current int `json:"current"`
+	// Duration is in milliseconds
+	Duration int64       `json:"duration"`
+	Layers   []layerJSON `json:"layers"`
+}
+
+type layerJSON struct {
+	Name    string  `json:"name"`
+	Visible bool    `json:"visible"`
+	Opacity float64 `json:"opacity"`
+	Blend   string  `json:"blend"`
+	File    string  `json:"file"`
+}
+
+type commandJSON struct {
+	Type    string        `json:"type"`
+	Changes []changeJSON  `json:"changes,omitempty"`
+	Before  *snapshotJSON `json:"before,omitempty"`
+	After   *snapshotJSON `json:"after,omitempty"`
+}
+
+type changeJSON struct {
+	Frame int    `json:"frame,omitempty"`
+	Layer int    `json:"layer"`
+	X     int    `json:"x"`
+	Y     int    `json:"y"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to"`
+}
+
+func isProjectFile(fileName string) bool {
+	return strings.EqualFold(filepath.Ext(fileName), projectExtension)
+}
+
+func saveProject(fileName string, p *project) error {
+	outFile, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := writeProject(outFile, p); err != nil {
+		outFile.Close()
+		return err
+	}
+	return outFile.Close()
+}
+
+func writeProject(w io.Writer, p *project) error {
+	zw := zip.NewWriter(w)
+	pw := projectWriter{zw, make(map[*image.NRGBA]string)}
+	snapshot, err := pw.writeSnapshot("image", p.m)
+	if err != nil {
+		return err
+	}
+	pj := projectJSON{
+		Version:     projectVersion,
+		MinVersion:  projectMinVersion,
+		Image:       snapshot,
+		PenColor:    encodeColor(p.penColor),
+		PaletteSize: p.paletteSize,
+		Fill:        fillJSON{p.fill.tolerance, p.fill.space.String(), p.fill.diagonal},
+		Cursor:      pointJSON{p.cursor.X, p.cursor.Y},
+		Pan:         pointJSON{p.pan.X, p.pan.Y},
+	}
+	if pj.Undo, err = pw.writeCommands("undo", p.history.undoStack); err != nil {
+		return err
+	}
+	if pj.Redo, err = pw.writeCommands("redo", p.history.redoStack); err != nil {
+		return err
+	}
+	f, err := zw.Create(projectManifest)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(pj); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// projectWriter writes the pixels of every layer once, the snapshots of the
+// history share the pixels of unchanged layers with the image.
+type projectWriter struct {
+	zw    *zip.Writer
+	files map[*image.NRGBA]string
+}
+
+func (pw projectWriter) writeSnapshot(prefix string, m *layeredImage) (snapshotJSON, error) {
+	b := m.Bounds()
+	result := snapshotJSON{
+		Min:     pointJSON{b.Min.X, b.Min.Y},
+		Max:     pointJSON{b.Max.X, b.Max.Y},
+		Current: m.current,
+	}
+	var err error
+	if result.Layers, err = pw.writeLayers(prefix, m.layers, b); err != nil {
+		return result, err
+	}
+	for _, c := range m.palette {
+		result.Palette = append(result.Palette, encodeColor(c))
+	}
+	if m.frameCount() < 2 && m.duration() == defaultFrameDuration && m.loopCount == 0 {
+		return result, nil
+	}
+	result.Frame = m.frame
+	result.LoopCount = m.loopCount
+	result.Frames = make([]frameJSON, m.frameCount())
+	for i, f := range m.frames {
+		fj := frameJSON{Current: f.current, Duration: f.duration.Milliseconds(), Layers: result.Layers}
+		if i != m.frame {
+			if fj.Layers, err = pw.writeLayers(fmt.Sprintf("%s/frame%d", prefix, i), f.layers, b); err != nil {
+				return result, err
+			}
+		} else {
+			fj.Current = m.current
+		}
+		result.Frames[i] = fj
+	}
+	return result, nil
+}
+
+func (pw projectWriter) writeLayers(prefix string, layers []*imageLayer, b image.Rectangle) ([]layerJSON, error) {
+	result := make([]layerJSON, len(layers))
+	for i, l := range layers {
+		fileName, ok := pw.files[l.pixels]
+		if !ok {
+			fileName = fmt.Sprintf("%s/layer%d.png", prefix, i)
+			f, err := pw.zw.Create(fileName)
+			if err != nil {
+				return nil, err
+			}
+			if err := png.Encode(f, layerImage{l, b}); err != nil {
+				return nil, err
+			}
+			pw.files[l.pixels] = fileName
+		}
+		result[i] = layerJSON{l.name, l.visible, l.opacity, l.blend.String(), fileName}
+	}
+	return result, nil
+}
+
+func (pw projectWriter) writeCommands(prefix string, commands []command) ([]commandJSON, error) {
+	result := make([]commandJSON, len(commands))
+	for i, cmd := range commands {
+		switch cmd := cmd.(type) {
+		case *pixelCommand:
+			changes := make([]changeJSON, len(cmd.changes))
+			for j, ch := range cmd.changes {
+				changes[j] = changeJSON{ch.frame, ch.layer, ch.point.X, ch.point.Y, "", encodeColor(ch.to)}
+				// pixels which were not painted are omitted
+				if toNRGBA(ch.from).A != 0 {
+					changes[j].From = encodeColor(ch.from)
+				}
+			}
+			result[i] = commandJSON{Type: "pixels", Changes: changes}
+		case *layersCommand:
+			before, err := pw.writeSnapshot(fmt.Sprintf("%s/%d/before", prefix, i), cmd.before)
+			if err != nil {
+				return nil, err
+			}
+			after, err := pw.writeSnapshot(fmt.Sprintf("%s/%d/after", prefix, i), cmd.after)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = commandJSON{Type: "layers", Before: &before, After: &after}
+		default:
+			return nil, fmt.Errorf("cannot save history item of type %T", cmd)
+		}
+	}
+	return result, nil
+}
+
+func loadProject(fileName string) (*project, error) {
+	r, err := zip.OpenReader(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readProject(&r.Reader)
+}
+
+func readProject(zr *zip.Reader) (*project, error) {
+	pr := projectReader{make(map[string]*zip.File), make(map[string]*image.NRGBA)}
+	for _, f := range zr.File {
+		pr.files[f.Name] = f
+	}
+	f, ok := pr.files[projectManifest]
+	if !ok {
+		return nil, fmt.Errorf("invalid project file: missing %s", projectManifest)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var pj projectJSON
+	if err := json.NewDecoder(rc).Decode(&pj); err != nil {
+		return nil, fmt.Errorf("invalid project file: %w", err)
+	}
+	if pj.Version < 1 {
+		return nil, fmt.Errorf("invalid project version %d", pj.Version)
+	}
+	if pj.MinVersion > projectVersion {
+		return nil, fmt.Errorf("project version %d is not supported, the project requires version %d but only version %d can be read", pj.Version, pj.MinVersion, projectVersion)
+	}
+
+	m, err := pr.readSnapshot(pj.Image)
+	if err != nil {
+		return nil, err
+	}
+	penColor, err := decodeColor(pj.PenColor)
+	if err != nil {
+		return nil, err
+	}
+	p := &project{
+		m:           m,
+		penColor:    penColor,
+		paletteSize: pj.PaletteSize,
+		fill: fillOptions{
+			tolerance: pj.Fill.Tolerance,
+			space:     parseColorSpace(pj.Fill.Space),
+			diagonal:  pj.Fill.Diagonal,
+		},
+		cursor:  image.Pt(pj.Cursor.X, pj.Cursor.Y),
+		pan:     image.Pt(pj.Pan.X, pj.Pan.Y),
+		history: newHistory(),
+	}
+	undo, err := pr.readCommands(pj.Undo)
+	var redo []command
+	if err == nil {
+		redo, err = pr.readCommands(pj.Redo)
+	}
+	if err == errUnknownCommand {
+		// history items added by newer versions can't be replayed, drop the
+		// whole history instead of corrupting the image on undo or redo
+		return p, nil
+	} else if err != nil {
+		return nil, err
+	}
+	p.history.undoStack, p.history.redoStack = undo, redo
+	return p, nil
+}
+
+// projectReader restores the sharing of pixels between the image and the
+// snapshots of the history, layers stored in the same file get the same
+// pixels.
+type projectReader struct {
+	files  map[string]*zip.File
+	pixels map[string]*image.NRGBA
+}
+
+func (pr projectReader) readSnapshot(s snapshotJSON) (*layeredImage, error) {
+	frames := s.Frames
+	if len(frames) == 0 {
+		frames = []frameJSON{{s.Current, defaultFrameDuration.Milliseconds(), s.Layers}}
+		s.Frame = 0
+	}
+	if s.Frame < 0 || s.Frame >= len(frames) {
+		return nil, fmt.Errorf("invalid project file: frame %d out of range", s.Frame)
+	}
+	result := &layeredImage{
+		bounds:    image.Rect(s.Min.X, s.Min.Y, s.Max.X, s.Max.Y),
+		frames:    make([]*frame, len(frames)),
+		frame:     s.Frame,
+		loopCount: s.LoopCount,
+	}
+	for i, fj := range frames {
+		if len(fj.Layers) == 0 {
+			return nil, errors.New("invalid project file: image without layers")
+		}
+		f := &frame{
+			current:  min(max(fj.Current, 0), len(fj.Layers)-1),
+			duration: time.Duration(fj.Duration) * time.Millisecond,
+		}
+		if f.duration <= 0 {
+			f.duration = defaultFrameDuration
+		}
+		var err error
+		if f.layers, err = pr.readLayers(fj.Layers, result.bounds); err != nil {
+			return nil, err
+		}
+		result.frames[i] = f
+	}
+	current := result.frames[s.Frame]
+	result.layers, result.current = current.layers, current.current
+	current.layers = nil
+	for _, cs := range s.Palette {
+		c, err := decodeColor(cs)
+		if err != nil {
+			return nil, err
+		}
+		result.palette = append(result.palette, c)
+	}
+	return result, nil
+}
+
+func (pr projectReader) readLayers(layers []layerJSON, b image.Rectangle) ([]*imageLayer, error) {
+	result := make([]*imageLayer, len(layers))
+	for i, lj := range layers {
+		l := newImageLayer(lj.Name, b)
+		l.visible = lj.Visible
+		l.opacity = lj.Opacity
+		l.blend = parseBlendMode(lj.Blend)
+		result[i] = l
+		if pixels, ok := pr.pixels[lj.File]; ok && pixels.Rect == b {
+			l.pixels = pixels
+			continue
+		}
+		m, err := pr.readImage(lj.File)
+		if err != nil {
+			return nil, err
+		}
+		// the layer images start at the origin
+		draw.Draw(l.pixels, b, m, m.Bounds().Min, draw.Src)
+		pr.pixels[lj.File] = l.pixels
+	}
+	return result, nil
+}
+
+func (pr projectReader) readImage(fileName string) (image.Image, error) {
+	f, ok := pr.files[fileName]
+	if !ok {
+		return nil, fmt.Errorf("invalid project file: missing %s", fileName)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return png.Decode(rc)
+}
+
+// errUnknownCommand is returned for history items of newer versions.
+var errUnknownCommand = errors.New("unknown history command")
+
+func (pr projectReader) readCommands(commands []commandJSON) ([]command, error) {
+	result := make([]command, 0, len(commands))
+	for _, cj := range commands {
+		switch cj.Type {
+		case "pixels":
+			cmd := newPixelCommand()
+			for _, ch := range cj.Changes {
+				change := pixelChange{frame: ch.Frame, layer: ch.Layer, point: image.Pt(ch.X, ch.Y), from: color.NRGBA{}}
+				var err error
+				if ch.From != "" {
+					if change.from, err = decodeColor(ch.From); err != nil {
+						return nil, err
+					}
+				}
+				if change.to, err = decodeColor(ch.To); err != nil {
+					return nil, err
+				}
+				cmd.index[change.point] = len(cmd.changes)
+				cmd.changes = append(cmd.changes, change)
+			}
+			result = append(result, cmd)
+		case "layers":
+			if cj.Before == nil || cj.After == nil {
+				return nil, errors.New("invalid project file: layer change without snapshots")
+			}
+			before, err := pr.readSnapshot(*cj.Before)
+			if err != nil {
+				return nil, err
+			}
+			after, err := pr.readSnapshot(*cj.After)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, &layersCommand{before, after})
+		default:
+			return nil, errUnknownCommand
+		}
+	}
+	return result, nil
+}
+
+// encodeColor returns the color as #rrggbbaa.
+func encodeColor(c color.Color) string {
+	n := toNRGBA(c)
+	return fmt.Sprintf("#%02x%02x%02x%02x", n.R, n.G, n.B, n.A)
+}
+
+// decodeColor parses colors in the #rrggbb and #rrggbbaa formats.
+func decodeColor(s string) (color.Color, error) {
+	var n color.NRGBA
+	var err error
+	switch len(s) {
+	case 7:
+		n.A = 255
+		_, err = fmt.Sscanf(s, "#%02x%02x%02x", &n.R, &n.G, &n.B)
+	case 9:
+		_, err = fmt.Sscanf(s, "#%02x%02x%02x%02x", &n.R, &n.G, &n.B, &n.A)
+	default:
+		err = errors.New("unexpected length")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid color %q: %w", s, err)
+	}
+	return n, nil
+}
+
+func parseBlendMode(s string) blendMode {
+	for bm := blendNormal; bm < blendModeCount; bm++ {
+		if bm.String() == s {
+			return bm
+		}
+	}
+	return blendNormal
+}
+
+func parseColorSpace(s string) colorSpace {
+	if s == colorSpaceLab.String() {
+		return colorSpaceLab
+	}
+	return colorSpaceRGB
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func Test_writeProject_roundtrip(t *testing.T) {
+	i, _ := createImage("4,3")
+	c := NewCmdPxl("test.cpxl", i, nil, nil)
+	c.changeLayers(func(m *layeredImage) bool {
+		m.addLayer()
+		m.activeLayer().opacity = 0.5
+		m.activeLayer().blend = blendScreen
+		return true
+	})
+	cmd := newPixelCommand()
+	cmd.set(c.m, image.Pt(1, 2), color.NRGBA{255, 0, 0, 255})
+	c.history.push(cmd)
+	cmd = newPixelCommand()
+	cmd.set(c.m, image.Pt(2, 2), color.NRGBA{0, 255, 0, 255})
+	c.history.push(cmd)
+	c.history.undo(c.m)
+	c.penColor = *NewCmdColor(color.NRGBA{0, 0, 255, 255}, c.paletteSize)
+	c.cursor = image.Pt(3, 1)
+	c.fill.tolerance = 0.25
+
+	b := new(bytes.Buffer)
+	if err := writeProject(b, c.project()); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the snapshots of the layer change share the pixels of the image
+	pngs := 0
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, ".png") {
+			pngs++
+		}
+	}
+	if pngs != 2 {
+		t.Errorf("Expected the pixels of 2 layers, got %d files", pngs)
+	}
+	p, err := readProject(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewCmdPxl("test.cpxl", p.m, nil, nil)
+	restored.restoreProject(p)
+
+	if restored.imageWidth != 3 || restored.imageHeight != 4 {
+		t.Errorf("Expected 3x4 image, got %dx%d", restored.imageWidth, restored.imageHeight)
+	}
+	if len(restored.m.layers) != 2 || restored.m.current != 1 {
+		t.Fatalf("Expected 2 layers with the top one selected, got %d", len(restored.m.layers))
+	}
+	l := restored.m.activeLayer()
+	if l.name != "Layer 1" || l.opacity != 0.5 || l.blend != blendScreen || !l.visible {
+		t.Errorf("Expected layer properties to be restored, got %s", l)
+	}
+	if !sameColor(restored.penColor.c, color.NRGBA{0, 0, 255, 255}) || restored.cursor != image.Pt(3, 1) || restored.fill.tolerance != 0.25 {
+		t.Errorf("Expected editor state to be restored")
+	}
+	if !restored.history.redo(restored.m) || !sameColor(l.at(image.Pt(2, 2)), color.NRGBA{0, 255, 0, 255}) {
+		t.Errorf("Expected redo to be restored")
+	}
+	for restored.history.undo(restored.m) {
+	}
+	if len(restored.m.layers) != 1 || !restored.m.layers[0].isEmpty() {
+		t.Errorf("Expected undo to restore the original image")
+	}
+}
+
+func Test_readProject_version(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		wantErr  string
+	}{
+		{
+			"newer incompatible version",
+			`{"version": 3, "minVersion": 2}`,
+			"not supported",
+		},
+		{
+			"invalid version",
+			`{"version": 0}`,
+			"invalid project version",
+		},
+		{
+			"newer compatible version with unknown fields",
+			`{"version": 2, "minVersion": 1, "future": true, "penColor": "#ffffff",
+			"image": {"max": {"x": 1, "y": 1}, "layers": [{"name": "a", "visible": true, "opacity": 1, "file": "a.png"}]},
+			"undo": [{"type": "future"}]}`,
+			"",
+		},
+		{
+			"unknown history in the redo stack only",
+			`{"version": 1, "minVersion": 1, "penColor": "#ffffff",
+			"image": {"max": {"x": 1, "y": 1}, "layers": [{"name": "a", "visible": true, "opacity": 1, "file": "a.png"}]},
+			"undo": [{"type": "pixels", "changes": [{"layer": 0, "x": 0, "y": 0, "to": "#ffffffff"}]}],
+			"redo": [{"type": "future"}]}`,
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := new(bytes.Buffer)
+			zw := zip.NewWriter(b)
+			f, _ := zw.Create(projectManifest)
+			f.Write([]byte(tt.manifest))
+			f, _ = zw.Create("a.png")
+			png.Encode(f, image.NewNRGBA(image.Rect(0, 0, 1, 1)))
+			zw.Close()
+			zr, _ := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+			p, err := readProject(zr)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("readProject() unexpected error %v", err)
+				}
+				if len(p.history.undoStack) != 0 || len(p.history.redoStack) != 0 {
+					t.Errorf("Expected unknown history to drop both stacks")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("readProject() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import "fmt"
+
+// renderMode describes how many terminal cells are used to draw a single
+// image pixel. The height is counted in half rows as half-block characters
+// show two pixels stacked in a single cell.
+type renderMode struct {
+	cols     int
+	halfRows int
+}
+
+// zoomLevels are the available render modes from the smallest to the
+// largest. Terminal cells are about twice as high as wide so every level
+// shows square pixels.
+var zoomLevels = []renderMode{
+	{1, 1},
+	{2, 2},
+	{4, 4},
+	{6, 6},
+	{8, 8},
+}
+
+const defaultZoom = 1
+
+// halfBlock returns the mode with half of the height using half-block
+// characters.
+func (rm renderMode) halfBlock() renderMode {
+	return renderMode{rm.cols, max(1, rm.halfRows/2)}
+}
+
+// String returns the size of a pixel in columns x rows.
+func (rm renderMode) String() string {
+	rows := ""
+	if rm.halfRows > 1 {
+		rows = fmt.Sprint(rm.halfRows / 2)
+	}
+	if rm.halfRows%2 == 1 {
+		rows += "½"
+	}
+	return fmt.Sprintf("%dx%s", rm.cols, rows)
+}
+
+// cells returns the number of terminal columns and rows needed to draw an
+// image of the given size.
+func (rm renderMode) cells(width, height int) (int, int) {
+	return width * rm.cols, (height*rm.halfRows + 1) / 2
+}
+
+// pixels returns the number of image pixels which fit in the given number of
+// terminal columns and rows.
+func (rm renderMode) pixels(cols, rows int) (int, int) {
+	return cols / rm.cols, rows * 2 / rm.halfRows
+}
+
+// cursorRune returns the rune drawn in the column of the cursor pixel when a
+// cell shows a single pixel.
+func (rm renderMode) cursorRune(col int) rune {
+	switch {
+	case rm.cols == 1:
+		return '+'
+	case col == 0:
+		return '['
+	case col == rm.cols-1:
+		return ']'
+	}
+	return ' '
+}
+
+func (c *CmdPxl) getRenderMode() renderMode {
+	mode := zoomLevels[c.zoom]
+	if c.halfBlock {
+		return mode.halfBlock()
+	}
+	return mode
+}
+
+// setRenderMode changes the zoom level and the half-block rendering keeping
+// the pixel under the cursor in the middle of the view.
+func (c *CmdPxl) setRenderMode(zoom int, halfBlock bool) {
+	c.zoom = min(max(zoom, 0), len(zoomLevels)-1)
+	c.halfBlock = halfBlock
+	c.layout()
+	c.pan = c.viewport().centerOn(c.cursor).pan
+	c.s.Clear()
+}
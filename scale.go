@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// scaler resizes an image.
+type scaler func(m *image.NRGBA) *image.NRGBA
+
+// scaleSpecs are the scalings offered in the scale menu.
+var scaleSpecs = []string{
+	"nearest:2", "nearest:3", "nearest:4",
+	"scale2x", "scale3x",
+	"box:2", "box:3", "box:4",
+}
+
+// parseScale returns the scaler for a specification of METHOD[:FACTOR]:
+// nearest:N upscales by repeating pixels, scale2x and scale3x use the EPX
+// family of pixel art scalers and box:N downscales by averaging blocks.
+func parseScale(spec string) (scaler, error) {
+	method, factor := spec, 0
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		method = spec[:i]
+		n, err := strconv.Atoi(spec[i+1:])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid scale factor %s", spec[i+1:])
+		}
+		factor = n
+	}
+	switch {
+	case method == "nearest" && factor > 0:
+		return func(m *image.NRGBA) *image.NRGBA { return scaleNearest(m, factor) }, nil
+	case method == "box" && factor > 0:
+		return func(m *image.NRGBA) *image.NRGBA { return scaleBox(m, factor) }, nil
+	case spec == "scale2x":
+		return scale2x, nil
+	case spec == "scale3x":
+		return scale3x, nil
+	}
+	return nil, fmt.Errorf("unknown scaling %s, use nearest:N, scale2x, scale3x or box:N", spec)
+}
+
+// scaleNearest enlarges every pixel to factor x factor pixels.
+func scaleNearest(m *image.NRGBA, factor int) *image.NRGBA {
+	b := m.Bounds()
+	result := image.NewNRGBA(image.Rect(0, 0, b.Dx()*factor, b.Dy()*factor))
+	for y := 0; y < result.Rect.Dy(); y++ {
+		for x := 0; x < result.Rect.Dx(); x++ {
+			result.SetNRGBA(x, y, m.NRGBAAt(b.Min.X+x/factor, b.Min.Y+y/factor))
+		}
+	}
+	return result
+}
+
+// neighbor returns the pixel at x+dx, y+dy repeating the edges of the
+// image.
+func neighbor(m *image.NRGBA, x, y, dx, dy int) color.NRGBA {
+	b := m.Bounds()
+	return m.NRGBAAt(min(max(x+dx, b.Min.X), b.Max.X-1), min(max(y+dy, b.Min.Y), b.Max.Y-1))
+}
+
+// scale2x doubles the image with the EPX algorithm which keeps edges sharp
+// instead of making them blocky.
+func scale2x(m *image.NRGBA) *image.NRGBA {
+	b := m.Bounds()
+	result := image.NewNRGBA(image.Rect(0, 0, b.Dx()*2, b.Dy()*2))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			//   a
+			// c p d
+			//   e
+			p := m.NRGBAAt(x, y)
+			a := neighbor(m, x, y, 0, -1)
+			c := neighbor(m, x, y, -1, 0)
+			d := neighbor(m, x, y, 1, 0)
+			e := neighbor(m, x, y, 0, 1)
+			out := [4]color.NRGBA{p, p, p, p}
+			if c == a && c != e && a != d {
+				out[0] = a
+			}
+			if a == d && a != c && d != e {
+				out[1] = d
+			}
+			if e == c && e != d && c != a {
+				out[2] = c
+			}
+			if d == e && d != a && e != c {
+				out[3] = e
+			}
+			ox, oy := (x-b.Min.X)*2, (y-b.Min.Y)*2
+			for i, cl := range out {
+				result.SetNRGBA(ox+i%2, oy+i/2, cl)
+			}
+		}
+	}
+	return result
+}
+
+// scale3x triples the image with the 3x variant of EPX.
+func scale3x(m *image.NRGBA) *image.NRGBA {
+	b := m.Bounds()
+	result := image.NewNRGBA(image.Rect(0, 0, b.Dx()*3, b.Dy()*3))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			// A B C
+			// D E F
+			// G H I
+			A, B, C := neighbor(m, x, y, -1, -1), neighbor(m, x, y, 0, -1), neighbor(m, x, y, 1, -1)
+			D, E, F := neighbor(m, x, y, -1, 0), m.NRGBAAt(x, y), neighbor(m, x, y, 1, 0)
+			G, H, I := neighbor(m, x, y, -1, 1), neighbor(m, x, y, 0, 1), neighbor(m, x, y, 1, 1)
+			out := [9]color.NRGBA{E, E, E, E, E, E, E, E, E}
+			if D == B && B != F && D != H {
+				out[0] = D
+			}
+			if (D == B && B != F && D != H && E != C) || (B == F && B != D && F != H && E != A) {
+				out[1] = B
+			}
+			if B == F && B != D && F != H {
+				out[2] = F
+			}
+			if (D == B && B != F && D != H && E != G) || (D == H && D != B && H != F && E != A) {
+				out[3] = D
+			}
+			if (B == F && B != D && F != H && E != I) || (H == F && D != H && B != F && E != C) {
+				out[5] = F
+			}
+			if D == H && D != B && H != F {
+				out[6] = D
+			}
+			if (H == F && D != H && B != F && E != G) || (D == H && D != B && H != F && E != I) {
+				out[7] = H
+			}
+			if H == F && D != H && B != F {
+				out[8] = F
+			}
+			ox, oy := (x-b.Min.X)*3, (y-b.Min.Y)*3
+			for i, cl := range out {
+				result.SetNRGBA(ox+i%3, oy+i/3, cl)
+			}
+		}
+	}
+	return result
+}
+
+// scaleBox shrinks the image by averaging blocks of factor x factor pixels,
+// blocks at the right and bottom edges can be smaller.
+func scaleBox(m *image.NRGBA, factor int) *image.NRGBA {
+	b := m.Bounds()
+	result := image.NewNRGBA(image.Rect(0, 0, (b.Dx()+factor-1)/factor, (b.Dy()+factor-1)/factor))
+	for y := 0; y < result.Rect.Dy(); y++ {
+		for x := 0; x < result.Rect.Dx(); x++ {
+			block := image.Rect(x*factor, y*factor, (x+1)*factor, (y+1)*factor).Add(b.Min).Intersect(b)
+			var r, g, bl, a uint64
+			for by := block.Min.Y; by < block.Max.Y; by++ {
+				for bx := block.Min.X; bx < block.Max.X; bx++ {
+					// premultiplied values keep transparent pixels from
+					// darkening the average
+					pr, pg, pb, pa := m.At(bx, by).RGBA()
+					r, g, bl, a = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa)
+				}
+			}
+			n := uint64(block.Dx() * block.Dy())
+			result.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	return result
+}
+
+// scaleImage replaces the layers of every frame with the scaled flattened
+// frame, indexed images keep their palette.
+func scaleImage(m *layeredImage, fn scaler) command {
+	return changeLayers(m, func(m *layeredImage) bool {
+		current, b := m.frame, m.bounds
+		var scaled image.Rectangle
+		for i := range m.frames {
+			m.selectFrame(i)
+			m.bounds = b
+			flat := newLayeredImage(fn(m.flatten()))
+			m.layers, m.current = flat.layers, flat.current
+			scaled = flat.bounds
+		}
+		m.bounds = scaled
+		m.selectFrame(current)
+		return true
+	})
+}
+
+func (c *CmdPxl) openScale() {
+	c.scaleMenu = newMenu("Scale", scaleSpecs)
+	c.currentState = stateScale
+}
+
+func (c *CmdPxl) closeScale() {
+	c.scaleMenu = nil
+	c.currentState = stateDrawing
+	c.s.Clear()
+}
+
+func (c *CmdPxl) handleScaleKey(ev *tcell.EventKey) {
+	switch {
+	case ev.Key() == tcell.KeyEscape || ev.Rune() == 'S' || ev.Rune() == 'x':
+		c.closeScale()
+	case ev.Key() == tcell.KeyEnter || ev.Rune() == 'e' || ev.Rune() == ' ':
+		fn, _ := parseScale(scaleSpecs[c.scaleMenu.selected])
+		c.transform(scaleImage(c.m, fn))
+		c.closeScale()
+	case ev.Key() == tcell.KeyUp || ev.Rune() == 'w':
+		c.scaleMenu.move(dirDecrease)
+	case ev.Key() == tcell.KeyDown || ev.Rune() == 's':
+		c.scaleMenu.move(dirIncrease)
+	}
+}
+
+func (c *CmdPxl) drawScaleMenu() *drawBox {
+	return c.scaleMenu.draw(c.s, 0, 0, c.interfaceStyle,
+		"layers are flattened",
+		"[e] apply [esc] cancel",
+	)
+}
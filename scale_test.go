@@ -0,0 +1,112 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// textImage creates an image from rows of text, # is black and . is white.
+func textImage(rows ...string) *image.NRGBA {
+	m := image.NewNRGBA(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x, r := range row {
+			if r == '#' {
+				m.Set(x, y, color.Black)
+			} else {
+				m.Set(x, y, color.White)
+			}
+		}
+	}
+	return m
+}
+
+func imageText(m *image.NRGBA) string {
+	b := m.Bounds()
+	rows := make([]string, 0, b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var row strings.Builder
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if m.NRGBAAt(x, y) == (color.NRGBA{0, 0, 0, 255}) {
+				row.WriteByte('#')
+			} else {
+				row.WriteByte('.')
+			}
+		}
+		rows = append(rows, row.String())
+	}
+	return strings.Join(rows, "\n")
+}
+
+func Test_parseScale(t *testing.T) {
+	diagonal := textImage(
+		"#.",
+		".#",
+	)
+	tests := []struct {
+		spec    string
+		want    []string
+		wantErr string
+	}{
+		{"nearest:2", []string{
+			"##..",
+			"##..",
+			"..##",
+			"..##",
+		}, ""},
+		{"scale2x", []string{
+			"##..",
+			"#.#.",
+			".#.#",
+			"..##",
+		}, ""},
+		{"scale3x", []string{
+			"###...",
+			"##.#..",
+			"#..##.",
+			".##..#",
+			"..#.##",
+			"...###",
+		}, ""},
+		{"box:0", nil, "invalid scale factor 0"},
+		{"bilinear", nil, "unknown scaling bilinear"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			fn, err := parseScale(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseScale() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := imageText(fn(diagonal)), strings.Join(tt.want, "\n"); got != want {
+				t.Errorf("%s got:\n%s\nwant:\n%s", tt.spec, got, want)
+			}
+		})
+	}
+}
+
+func Test_scaleBox(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	m.Set(0, 0, color.White)
+	m.Set(1, 0, color.Black)
+	m.Set(0, 1, color.White)
+	m.Set(1, 1, color.Black)
+	m.Set(2, 0, color.NRGBA{255, 0, 0, 255})
+	got := scaleBox(m, 2)
+	if got.Bounds() != image.Rect(0, 0, 2, 1) {
+		t.Fatalf("Expected 2x1 image, got %v", got.Bounds())
+	}
+	if c := got.NRGBAAt(0, 0); c != (color.NRGBA{127, 127, 127, 255}) {
+		t.Errorf("Expected gray average, got %v", c)
+	}
+	// the transparent pixel below only lowers the alpha
+	if c := got.NRGBAAt(1, 0); c != (color.NRGBA{255, 0, 0, 127}) {
+		t.Errorf("Expected half transparent red, got %v", c)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// scriptRunner executes editing scripts without the interactive interface.
+//
+// Scripts contain a single operation per line, empty lines and lines
+// starting with # are ignored:
+//
+//	color #rrggbb[aa]            set the pen color
+//	set X Y [#color]             paint a single pixel
+//	fill X Y [#color] [options]  flood fill, options are tolerance=N, 8way, lab and global
+//	filter NAME [AMOUNT]         apply a filter to the whole layer
+//	resize WIDTH HEIGHT [ANCHOR] change the canvas size, anchors are top-left, top, center, ...
+//	flip horizontal|vertical     mirror the image
+//	rotate cw|ccw                rotate the image by 90°
+//	crop X Y WIDTH HEIGHT        crop the image to the rectangle
+//	trim                         crop the transparent borders
+//	scale SPEC                   scale the flattened image, see parseScale
+//	indexed                      convert the image to indexed colors
+//	palette INDEX #rrggbb[aa]    change a palette entry and recolor its pixels
+//	slice WIDTH HEIGHT           split the sprite sheet into frames of the cell size
+//	sheet PATH [options]         export a sprite sheet with a JSON atlas, options are grid, strip, frames, layers and columns=N
+//	save [PATH]                  save the image, defaults to the opened file
+type scriptRunner struct {
+	fileName  string
+	m         *layeredImage
+	history   *history
+	penColor  color.Color
+	saveImage saveImageCallback
+}
+
+func newScriptRunner(fileName string, m image.Image, saveImage saveImageCallback) *scriptRunner {
+	li, ok := m.(*layeredImage)
+	if !ok {
+		li = newLayeredImage(m)
+	}
+	return &scriptRunner{
+		fileName:  fileName,
+		m:         li,
+		history:   newHistory(),
+		penColor:  color.White,
+		saveImage: saveImage,
+	}
+}
+
+func (sr *scriptRunner) run(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := sr.exec(strings.Fields(line)); err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (sr *scriptRunner) exec(args []string) error {
+	op, args := args[0], args[1:]
+	switch op {
+	case "color":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: color #rrggbb[aa]")
+		}
+		c, err := decodeColor(args[0])
+		if err != nil {
+			return err
+		}
+		sr.penColor = c
+	case "set":
+		if len(args) < 2 || len(args) > 3 {
+			return fmt.Errorf("usage: set X Y [#color]")
+		}
+		p, err := sr.parsePoint(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		c, err := sr.parseOptionalColor(args[2:])
+		if err != nil {
+			return err
+		}
+		sr.do(paintPixel(sr.m, p, c))
+	case "fill":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: fill X Y [#color] [tolerance=N] [8way] [lab] [global]")
+		}
+		p, err := sr.parsePoint(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		args = args[2:]
+		c, err := sr.parseOptionalColor(args)
+		if err != nil {
+			return err
+		}
+		if len(args) > 0 && strings.HasPrefix(args[0], "#") {
+			args = args[1:]
+		}
+		opts, err := parseFillOptions(args)
+		if err != nil {
+			return err
+		}
+		sr.do(fillAt(sr.m, p, c, opts, sr.m.Bounds()))
+	case "filter":
+		if len(args) < 1 || len(args) > 2 {
+			return fmt.Errorf("usage: filter NAME [AMOUNT]")
+		}
+		f, ok := findFilter(args[0])
+		if !ok {
+			return fmt.Errorf("unknown filter %s", args[0])
+		}
+		if len(args) == 2 {
+			af, ok := f.(adjustableFilter)
+			if !ok {
+				return fmt.Errorf("filter %s has no amount", args[0])
+			}
+			amount, err := strconv.ParseFloat(args[1], 64)
+			if err != nil {
+				return fmt.Errorf("invalid amount %s", args[1])
+			}
+			af.SetParam(amount)
+		}
+		sr.do(filterLayer(sr.m, f, sr.m.Bounds()))
+	case "resize":
+		if len(args) < 2 || len(args) > 3 {
+			return fmt.Errorf("usage: resize WIDTH HEIGHT [ANCHOR]")
+		}
+		w, err := strconv.Atoi(args[0])
+		if err != nil || w < 1 {
+			return fmt.Errorf("invalid width %s", args[0])
+		}
+		h, err := strconv.Atoi(args[1])
+		if err != nil || h < 1 {
+			return fmt.Errorf("invalid height %s", args[1])
+		}
+		a := anchorTopLeft
+		if len(args) == 3 {
+			if a, err = parseAnchor(args[2]); err != nil {
+				return err
+			}
+		}
+		sr.do(resizeCanvas(sr.m, w, h, a))
+	case "flip":
+		if len(args) != 1 || (args[0] != "horizontal" && args[0] != "vertical") {
+			return fmt.Errorf("usage: flip horizontal|vertical")
+		}
+		sr.do(flipImage(sr.m, args[0] == "horizontal"))
+	case "rotate":
+		if len(args) != 1 || (args[0] != "cw" && args[0] != "ccw") {
+			return fmt.Errorf("usage: rotate cw|ccw")
+		}
+		sr.do(rotateImage(sr.m, args[0] == "cw"))
+	case "crop":
+		if len(args) != 4 {
+			return fmt.Errorf("usage: crop X Y WIDTH HEIGHT")
+		}
+		var r [4]int
+		for i, arg := range args {
+			v, err := strconv.Atoi(arg)
+			if err != nil {
+				return fmt.Errorf("invalid number %s", arg)
+			}
+			r[i] = v
+		}
+		sr.do(cropImage(sr.m, image.Rect(r[0], r[1], r[0]+r[2], r[1]+r[3])))
+	case "trim":
+		if len(args) != 0 {
+			return fmt.Errorf("usage: trim")
+		}
+		sr.do(trimImage(sr.m))
+	case "scale":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: scale nearest:N|scale2x|scale3x|box:N")
+		}
+		fn, err := parseScale(args[0])
+		if err != nil {
+			return err
+		}
+		sr.do(scaleImage(sr.m, fn))
+	case "indexed":
+		if len(args) != 0 {
+			return fmt.Errorf("usage: indexed")
+		}
+		sr.do(indexColors(sr.m))
+	case "palette":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: palette INDEX #rrggbb[aa]")
+		}
+		if sr.m.palette == nil {
+			return fmt.Errorf("the image has no palette, use indexed first")
+		}
+		i, err := strconv.Atoi(args[0])
+		if err != nil || i < 0 || i >= len(sr.m.palette) {
+			return fmt.Errorf("invalid palette index %s", args[0])
+		}
+		c, err := decodeColor(args[1])
+		if err != nil {
+			return err
+		}
+		if j := findColor(sr.m.palette, c); j >= 0 && j != i {
+			return fmt.Errorf("the color %s is already palette entry %d", args[1], j)
+		}
+		sr.do(setPaletteColor(sr.m, i, c))
+	case "slice":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: slice WIDTH HEIGHT")
+		}
+		w, h, err := parseCellSize(args[0] + "x" + args[1])
+		if err != nil {
+			return err
+		}
+		cmd, err := sliceImage(sr.m, w, h)
+		if err != nil {
+			return err
+		}
+		sr.do(cmd)
+	case "sheet":
+		if len(args) < 1 {
+			return fmt.Errorf("usage: sheet PATH [grid|strip] [frames|layers] [columns=N]")
+		}
+		opts, err := parseSheetOptions(args[1:])
+		if err != nil {
+			return err
+		}
+		return writeSheet(args[0], sr.m, opts, sr.saveImage)
+	case "save":
+		if len(args) > 1 {
+			return fmt.Errorf("usage: save [PATH]")
+		}
+		fileName := sr.fileName
+		if len(args) == 1 {
+			fileName = args[0]
+		}
+		return sr.save(fileName)
+	default:
+		return fmt.Errorf("unknown operation %s", op)
+	}
+	return nil
+}
+
+func (sr *scriptRunner) do(cmd command) {
+	if cmd != nil {
+		sr.history.push(cmd)
+	}
+}
+
+func (sr *scriptRunner) save(fileName string) error {
+	if fileName == "" {
+		return fmt.Errorf("no file name to save to")
+	}
+	if isProjectFile(fileName) {
+		return saveProject(fileName, &project{
+			m:        sr.m,
+			penColor: sr.penColor,
+			history:  sr.history,
+		})
+	}
+	return sr.saveImage(fileName, sr.m)
+}
+
+func (sr *scriptRunner) parsePoint(xs, ys string) (image.Point, error) {
+	x, err := strconv.Atoi(xs)
+	if err != nil {
+		return image.Point{}, fmt.Errorf("invalid x coordinate %s", xs)
+	}
+	y, err := strconv.Atoi(ys)
+	if err != nil {
+		return image.Point{}, fmt.Errorf("invalid y coordinate %s", ys)
+	}
+	p := image.Pt(x, y)
+	if !p.In(sr.m.Bounds()) {
+		return p, fmt.Errorf("point %s is outside of the image %s", p, sr.m.Bounds())
+	}
+	return p, nil
+}
+
+// parseOptionalColor returns the color if the first argument is one or the
+// pen color otherwise.
+func (sr *scriptRunner) parseOptionalColor(args []string) (color.Color, error) {
+	if len(args) > 0 && strings.HasPrefix(args[0], "#") {
+		return decodeColor(args[0])
+	}
+	return sr.penColor, nil
+}
+
+func parseFillOptions(args []string) (fillOptions, error) {
+	var opts fillOptions
+	for _, arg := range args {
+		switch {
+		case arg == "8way":
+			opts.diagonal = true
+		case arg == "lab":
+			opts.space = colorSpaceLab
+		case arg == "global":
+			opts.global = true
+		case strings.HasPrefix(arg, "tolerance="):
+			tolerance, err := strconv.ParseFloat(strings.TrimPrefix(arg, "tolerance="), 64)
+			if err != nil || tolerance < 0 || tolerance > 1 {
+				return opts, fmt.Errorf("invalid tolerance %s", arg)
+			}
+			opts.tolerance = tolerance
+		default:
+			return opts, fmt.Errorf("unknown fill option %s", arg)
+		}
+	}
+	return opts, nil
+}
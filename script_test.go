@@ -0,0 +1,84 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func Test_scriptRunner_run(t *testing.T) {
+	i, _ := createImage("4,4")
+	var saved image.Image
+	var savedName string
+	sr := newScriptRunner("out.png", i, func(fileName string, m image.Image) error {
+		savedName = fileName
+		saved = m
+		return nil
+	})
+	script := `
+# draw a red border pixel and fill the rest
+color #ff0000
+set 0 0
+set 1 0 #00ff00ff
+fill 2 2 #0000ff
+filter invert
+resize 3 2
+save
+`
+	if err := sr.run(strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+	if savedName != "out.png" {
+		t.Errorf("Expected image to be saved to out.png, got %q", savedName)
+	}
+	if b := saved.Bounds(); b.Dx() != 3 || b.Dy() != 2 {
+		t.Errorf("Expected 3x2 image, got %s", b)
+	}
+	for _, tt := range []struct {
+		p    image.Point
+		want color.Color
+	}{
+		{image.Pt(0, 0), color.NRGBA{0, 255, 255, 255}},
+		{image.Pt(1, 0), color.NRGBA{255, 0, 255, 255}},
+		{image.Pt(2, 1), color.NRGBA{255, 255, 0, 255}},
+	} {
+		if got := saved.At(tt.p.X, tt.p.Y); !sameColor(got, tt.want) {
+			t.Errorf("Expected %v at %s, got %v", tt.want, tt.p, got)
+		}
+	}
+	if len(sr.history.undoStack) != 5 {
+		t.Errorf("Expected 5 history items, got %d", len(sr.history.undoStack))
+	}
+}
+
+func Test_scriptRunner_errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		wantErr string
+	}{
+		{"unknown operation", "paint 1 1", "line 1: unknown operation paint"},
+		{"outside of the image", "\nset 5 5", "line 2: point (5,5) is outside"},
+		{"invalid color", "color red", "invalid color"},
+		{"unknown filter", "filter blur", "unknown filter blur"},
+		{"invalid fill option", "fill 0 0 fast", "unknown fill option fast"},
+		{"unknown anchor", "resize 2 2 middle", "unknown anchor middle"},
+		{"invalid rotation", "rotate 90", "usage: rotate cw|ccw"},
+		{"unknown scaling", "scale hq2x", "unknown scaling hq2x"},
+		{"palette without indices", "palette 0 #000000", "the image has no palette"},
+		{"invalid palette index", "indexed\npalette 1 #000000", "line 2: invalid palette index 1"},
+		{"repeated palette color", "color #000000\nset 0 0\nindexed\npalette 1 #000000", "line 4: the color #000000 is already palette entry 0"},
+		{"slice larger than the image", "slice 8 8", "smaller than a 8x8 cell"},
+		{"unknown sheet option", "sheet out.png packed", "unknown sheet option packed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, _ := createImage("4,4")
+			err := newScriptRunner("out.png", i, nil).run(strings.NewReader(tt.script))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("run() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// clip is a copied part of a layer, the points are relative to the top left
+// corner of the copied rectangle.
+type clip struct {
+	pixels layer
+	size   image.Point
+}
+
+// copyRect copies the pixels of the current layer within r.
+func copyRect(m *layeredImage, r image.Rectangle) *clip {
+	r = r.Intersect(m.Bounds())
+	cl := &clip{pixels: layer{}, size: r.Size()}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			p := image.Pt(x, y)
+			if c := m.activeLayer().at(p); c.A != 0 {
+				cl.pixels[p.Sub(r.Min)] = c
+			}
+		}
+	}
+	return cl
+}
+
+// floating is pasted content which is not part of the image until it is
+// anchored.
+type floating struct {
+	*clip
+	pos image.Point
+}
+
+func (f *floating) bounds() image.Rectangle {
+	return image.Rectangle{f.pos, f.pos.Add(f.size)}
+}
+
+// layer returns the pasted pixels within the bounds of the image.
+func (f *floating) layer(bounds image.Rectangle) layer {
+	l := layer{}
+	for p, c := range f.pixels {
+		if p = p.Add(f.pos); p.In(bounds) {
+			l[p] = c
+		}
+	}
+	return l
+}
+
+// selectionBounds returns the selected rectangle or the whole image when
+// nothing is selected.
+func (c *CmdPxl) selectionBounds() image.Rectangle {
+	if c.selection.Empty() {
+		return c.m.Bounds()
+	}
+	return c.selection
+}
+
+// toggleMarking starts selecting a rectangle from the cursor or finishes the
+// selection.
+func (c *CmdPxl) toggleMarking() {
+	if c.marking {
+		c.marking = false
+		return
+	}
+	c.cancelShape()
+	c.marking = true
+	c.markAnchor = c.cursor
+	c.updateMarking()
+}
+
+// updateMarking stretches the selection between the anchor and the cursor.
+func (c *CmdPxl) updateMarking() {
+	r := image.Rectangle{c.markAnchor, c.cursor}.Canon()
+	r.Max = r.Max.Add(image.Pt(1, 1))
+	c.selection = r.Intersect(c.m.Bounds())
+}
+
+func (c *CmdPxl) selectNone() {
+	c.marking = false
+	c.selection = image.Rectangle{}
+}
+
+func (c *CmdPxl) copySelection() {
+	c.marking = false
+	c.clipboard = copyRect(c.m, c.selectionBounds())
+}
+
+func (c *CmdPxl) cutSelection() {
+	c.copySelection()
+	c.do(clearRect(c.m, c.selectionBounds()))
+}
+
+// paste shows the clipboard as floating content at the cursor.
+func (c *CmdPxl) paste() {
+	if c.clipboard == nil {
+		return
+	}
+	c.penUp()
+	c.cancelShape()
+	c.marking = false
+	c.floating = &floating{clip: c.clipboard, pos: c.cursor}
+	c.currentState = stateFloating
+	c.updateFloating()
+}
+
+func (c *CmdPxl) updateFloating() {
+	c.selection = c.floating.bounds()
+	c.preview = c.floating.layer(c.m.Bounds())
+}
+
+// closeFloating anchors the floating content as a single history item or
+// drops it.
+func (c *CmdPxl) closeFloating(anchor bool) {
+	if anchor {
+		c.do(commitLayer(c.m, c.preview))
+		c.selection = c.selection.Intersect(c.m.Bounds())
+	} else {
+		c.selection = image.Rectangle{}
+	}
+	c.floating = nil
+	c.preview = nil
+	c.currentState = stateDrawing
+}
+
+func (c *CmdPxl) handleFloatingKey(ev *tcell.EventKey) {
+	move := func(dx, dy int) {
+		c.floating.pos = c.floating.pos.Add(image.Pt(dx, dy))
+		c.cursor = c.floating.pos
+		c.pan = c.viewport().scrollTo(c.cursor).pan
+		c.updateFloating()
+	}
+	switch {
+	case ev.Key() == tcell.KeyEscape || ev.Rune() == 'x':
+		c.closeFloating(false)
+	case ev.Key() == tcell.KeyEnter || ev.Rune() == 'e' || ev.Rune() == ' ':
+		c.closeFloating(true)
+	case ev.Rune() == 'w':
+		move(0, -1)
+	case ev.Rune() == 's':
+		move(0, 1)
+	case ev.Rune() == 'a':
+		move(-1, 0)
+	case ev.Rune() == 'd':
+		move(1, 0)
+	}
+}
+
+// antsTick is posted to the event loop to move the selection outline.
+type antsTick struct{}
+
+// scheduleAnts moves the outline after a while as long as a selection is
+// shown, the editor stays idle otherwise.
+func (c *CmdPxl) scheduleAnts() {
+	if c.selection.Empty() || c.antsScheduled {
+		return
+	}
+	c.antsScheduled = true
+	time.AfterFunc(tickInterval, func() {
+		_ = c.s.PostEvent(tcell.NewEventInterrupt(antsTick{}))
+	})
+}
+
+// antColor returns the color of the marching outline if the pixel is on the
+// edge of the selection.
+func (c *CmdPxl) antColor(p image.Point) (color.Color, bool) {
+	r := c.selection
+	if !p.In(r) || (p.X != r.Min.X && p.X != r.Max.X-1 && p.Y != r.Min.Y && p.Y != r.Max.Y-1) {
+		return nil, false
+	}
+	if wrap(p.X+p.Y-c.antsPhase, 4) < 2 {
+		return color.Black, true
+	}
+	return color.White, true
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func Test_copyRect(t *testing.T) {
+	i, _ := createImage("3,3")
+	m := newLayeredImage(i)
+	m.Set(image.Pt(1, 1), color.White)
+	m.Set(image.Pt(2, 2), color.Black)
+	cl := copyRect(m, image.Rect(1, 1, 5, 5))
+	if cl.size != image.Pt(2, 2) {
+		t.Errorf("Expected the copy to be clipped to the image, got size %v", cl.size)
+	}
+	white, black := color.NRGBA{255, 255, 255, 255}, color.NRGBA{0, 0, 0, 255}
+	want := layer{image.Pt(0, 0): white, image.Pt(1, 1): black}
+	if !reflect.DeepEqual(cl.pixels, want) {
+		t.Errorf("copyRect() = %v, want %v", cl.pixels, want)
+	}
+
+	f := &floating{clip: cl, pos: image.Pt(2, -1)}
+	want = layer{image.Pt(3, 0): black}
+	if got := f.layer(image.Rect(0, 0, 4, 4)); !reflect.DeepEqual(got, want) {
+		t.Errorf("floating.layer() = %v, want %v", got, want)
+	}
+}
+
+func Test_antColor(t *testing.T) {
+	c := &CmdPxl{selection: image.Rect(0, 0, 8, 3)}
+	for phase, want := range []string{"BBWWBBWW", "WBBWWBBW"} {
+		c.antsPhase = phase
+		got := ""
+		for x := 0; x < 8; x++ {
+			cl, ok := c.antColor(image.Pt(x, 0))
+			if !ok {
+				t.Fatalf("Expected (%d,0) to be on the outline", x)
+			}
+			if sameColor(cl, color.Black) {
+				got += "B"
+			} else {
+				got += "W"
+			}
+		}
+		if got != want {
+			t.Errorf("antColor() at phase %d = %s, want %s", phase, got, want)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// tool selects what drawing with the cursor does.
+type tool int
+
+const (
+	toolPencil tool = iota
+	toolLine
+	toolRectangle
+	toolFilledRectangle
+	toolEllipse
+	toolFilledEllipse
+	toolCount
+)
+
+func (t tool) String() string {
+	switch t {
+	case toolLine:
+		return "line"
+	case toolRectangle:
+		return "rectangle"
+	case toolFilledRectangle:
+		return "filled rectangle"
+	case toolEllipse:
+		return "ellipse"
+	case toolFilledEllipse:
+		return "filled ellipse"
+	}
+	return "pencil"
+}
+
+// shape is drawn by a shape tool between two corner points.
+type shape struct {
+	tool      tool
+	from, to  image.Point
+	constrain bool
+}
+
+// end returns the end point, constrained to 45° lines, squares and circles if
+// requested.
+func (s shape) end() image.Point {
+	if !s.constrain {
+		return s.to
+	}
+	d := s.to.Sub(s.from)
+	dx, dy := abs(d.X), abs(d.Y)
+	if s.tool == toolLine {
+		switch {
+		case dx > 2*dy:
+			return image.Pt(s.to.X, s.from.Y)
+		case dy > 2*dx:
+			return image.Pt(s.from.X, s.to.Y)
+		}
+	}
+	size := max(dx, dy)
+	if s.tool == toolLine {
+		return s.from.Add(image.Pt(sign(d.X)*size, sign(d.Y)*size))
+	}
+	// squares grow to the right and down from a straight drag
+	sx, sy := 1, 1
+	if d.X < 0 {
+		sx = -1
+	}
+	if d.Y < 0 {
+		sy = -1
+	}
+	return s.from.Add(image.Pt(sx*size, sy*size))
+}
+
+// plot calls fn for every point of the shape, points can repeat.
+func (s shape) plot(fn func(image.Point)) {
+	to := s.end()
+	switch s.tool {
+	case toolLine:
+		plotLine(s.from, to, fn)
+	case toolRectangle, toolFilledRectangle:
+		plotRectangle(s.from, to, s.tool == toolFilledRectangle, fn)
+	case toolEllipse, toolFilledEllipse:
+		plotEllipse(s.from, to, s.tool == toolFilledEllipse, fn)
+	default:
+		fn(s.from)
+	}
+}
+
+// layer returns the pixels of the shape within the bounds painted with c.
+func (s shape) layer(bounds image.Rectangle, c color.Color) layer {
+	l := layer{}
+	s.plot(func(p image.Point) {
+		if p.In(bounds) {
+			l[p] = c
+		}
+	})
+	return l
+}
+
+// plotLine plots a line with the Bresenham algorithm.
+func plotLine(p0, p1 image.Point, fn func(image.Point)) {
+	dx, dy := abs(p1.X-p0.X), -abs(p1.Y-p0.Y)
+	sx, sy := sign(p1.X-p0.X), sign(p1.Y-p0.Y)
+	err := dx + dy
+	for {
+		fn(p0)
+		if p0 == p1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			p0.X += sx
+		}
+		if e2 <= dx {
+			err += dx
+			p0.Y += sy
+		}
+	}
+}
+
+func plotRectangle(p0, p1 image.Point, filled bool, fn func(image.Point)) {
+	r := image.Rectangle{p0, p1}.Canon()
+	for y := r.Min.Y; y <= r.Max.Y; y++ {
+		for x := r.Min.X; x <= r.Max.X; x++ {
+			if filled || y == r.Min.Y || y == r.Max.Y || x == r.Min.X || x == r.Max.X {
+				fn(image.Pt(x, y))
+			}
+		}
+	}
+}
+
+// plotEllipse plots the ellipse inscribed in the rectangle between the
+// points with the midpoint algorithm. The bounding box variant handles
+// ellipses with an even width or height.
+func plotEllipse(p0, p1 image.Point, filled bool, fn func(image.Point)) {
+	r := image.Rectangle{p0, p1}.Canon()
+	x0, y0, x1, y1 := r.Min.X, r.Min.Y, r.Max.X, r.Max.Y
+	a, b := x1-x0, y1-y0
+	b1 := b & 1
+	dx, dy := 4*(1-a)*b*b, 4*(b1+1)*a*a
+	err := dx + dy + b1*a*a
+
+	// spans stores the outline of every row to fill the ellipse
+	spans := map[int][2]int{}
+	set := func(x, y int) {
+		if !filled {
+			fn(image.Pt(x, y))
+			return
+		}
+		span, ok := spans[y]
+		if !ok {
+			span = [2]int{x, x}
+		}
+		spans[y] = [2]int{min(span[0], x), max(span[1], x)}
+	}
+
+	y0 += (b + 1) / 2
+	y1 = y0 - b1
+	a, b1 = 8*a*a, 8*b*b
+	for x0 <= x1 {
+		set(x1, y0)
+		set(x0, y0)
+		set(x0, y1)
+		set(x1, y1)
+		e2 := 2 * err
+		if e2 <= dy {
+			y0++
+			y1--
+			dy += a
+			err += dy
+		}
+		if e2 >= dx || 2*err > dy {
+			x0++
+			x1--
+			dx += b1
+			err += dx
+		}
+	}
+	// finish the tips of flat ellipses
+	for y0-y1 <= b {
+		set(x0-1, y0)
+		set(x1+1, y0)
+		set(x0-1, y1)
+		set(x1+1, y1)
+		y0++
+		y1--
+	}
+
+	for y := r.Min.Y; y <= r.Max.Y; y++ {
+		if span, ok := spans[y]; ok {
+			for x := span[0]; x <= span[1]; x++ {
+				fn(image.Pt(x, y))
+			}
+		}
+	}
+}
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+func sign(a int) int {
+	switch {
+	case a < 0:
+		return -1
+	case a > 0:
+		return 1
+	}
+	return 0
+}
+
+// selectTool switches the tool, a shape in progress is dropped.
+func (c *CmdPxl) selectTool(t tool) {
+	c.penUp()
+	c.cancelShape()
+	c.tool = t
+}
+
+// useTool draws with the current tool at the cursor. Shape tools anchor the
+// shape on the first use and paint it on the second.
+func (c *CmdPxl) useTool() {
+	switch {
+	case c.tool == toolPencil:
+		c.do(paintPixel(c.m, c.cursor, c.penColor.c))
+	case c.shape == nil:
+		c.shape = &shape{tool: c.tool, from: c.cursor, to: c.cursor}
+		c.updateShape(false)
+	default:
+		c.commitShape()
+	}
+}
+
+// updateShape moves the end of the shape to the cursor and updates the
+// preview.
+func (c *CmdPxl) updateShape(constrain bool) {
+	c.shape.to = c.cursor
+	c.shape.constrain = constrain
+	c.preview = c.shape.layer(c.m.Bounds(), c.penColor.c)
+}
+
+// commitShape paints the shape as a single history item.
+func (c *CmdPxl) commitShape() {
+	c.do(drawShape(c.m, *c.shape, c.penColor.c))
+	c.cancelShape()
+}
+
+func (c *CmdPxl) cancelShape() {
+	if c.shape != nil {
+		c.shape = nil
+		c.preview = nil
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// plotString renders the points of the shape within w x h as text.
+func plotString(s shape, w, h int) string {
+	rows := make([][]byte, h)
+	for y := range rows {
+		rows[y] = []byte(strings.Repeat(".", w))
+	}
+	s.plot(func(p image.Point) {
+		rows[p.Y][p.X] = '#'
+	})
+	lines := make([]string, h)
+	for y, row := range rows {
+		lines[y] = string(row)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func Test_shape_plot(t *testing.T) {
+	tests := []struct {
+		name  string
+		shape shape
+		want  []string
+	}{
+		{
+			"line",
+			shape{tool: toolLine, from: image.Pt(0, 0), to: image.Pt(4, 2)},
+			[]string{
+				"#....",
+				".##..",
+				"...##",
+			},
+		},
+		{
+			"line constrained to 45°",
+			shape{tool: toolLine, from: image.Pt(4, 0), to: image.Pt(1, 2), constrain: true},
+			[]string{
+				"....#",
+				"...#.",
+				"..#..",
+				".#...",
+			},
+		},
+		{
+			"line constrained to horizontal",
+			shape{tool: toolLine, from: image.Pt(0, 1), to: image.Pt(4, 0), constrain: true},
+			[]string{
+				".....",
+				"#####",
+			},
+		},
+		{
+			"rectangle",
+			shape{tool: toolRectangle, from: image.Pt(3, 2), to: image.Pt(0, 0)},
+			[]string{
+				"####",
+				"#..#",
+				"####",
+			},
+		},
+		{
+			"square",
+			shape{tool: toolFilledRectangle, from: image.Pt(0, 0), to: image.Pt(2, 1), constrain: true},
+			[]string{
+				"###",
+				"###",
+				"###",
+			},
+		},
+		{
+			"ellipse",
+			shape{tool: toolEllipse, from: image.Pt(0, 0), to: image.Pt(6, 4)},
+			[]string{
+				"..###..",
+				".#...#.",
+				"#.....#",
+				".#...#.",
+				"..###..",
+			},
+		},
+		{
+			"filled circle with even size",
+			shape{tool: toolFilledEllipse, from: image.Pt(0, 0), to: image.Pt(3, 1), constrain: true},
+			[]string{
+				".##.",
+				"####",
+				"####",
+				".##.",
+			},
+		},
+		{
+			"flat ellipse",
+			shape{tool: toolEllipse, from: image.Pt(0, 0), to: image.Pt(5, 1)},
+			[]string{
+				"######",
+				"######",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := strings.Join(tt.want, "\n")
+			if got := plotString(tt.shape, len(tt.want[0]), len(tt.want)); got != want {
+				t.Errorf("shape.plot() got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func Test_shape_layer(t *testing.T) {
+	s := shape{tool: toolLine, from: image.Pt(-1, 0), to: image.Pt(2, 0)}
+	l := s.layer(image.Rect(0, 0, 2, 2), nil)
+	want := []image.Point{image.Pt(0, 0), image.Pt(1, 0)}
+	if got := l.points(); !reflect.DeepEqual(got, want) {
+		t.Errorf("shape.layer() = %v, want %v", got, want)
+	}
+}
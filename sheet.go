@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sheetOptions controls the layout of sprite sheets.
+type sheetOptions struct {
+	// layers puts the layers of the current frame on the sheet instead of
+	// the frames.
+	layers bool
+	// strip puts all cells in a single row, otherwise the cells are arranged
+	// in a grid with columns columns.
+	strip   bool
+	columns int
+}
+
+// parseSheetOptions parses the options grid, strip, frames, layers and
+// columns=N.
+func parseSheetOptions(args []string) (sheetOptions, error) {
+	var opts sheetOptions
+	for _, arg := range args {
+		switch {
+		case arg == "grid":
+			opts.strip = false
+		case arg == "strip":
+			opts.strip = true
+		case arg == "frames":
+			opts.layers = false
+		case arg == "layers":
+			opts.layers = true
+		case strings.HasPrefix(arg, "columns="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "columns="))
+			if err != nil || n < 1 {
+				return opts, fmt.Errorf("invalid number of columns %s", arg)
+			}
+			opts.columns = n
+		default:
+			return opts, fmt.Errorf("unknown sheet option %s", arg)
+		}
+	}
+	return opts, nil
+}
+
+// sheetCell is a single sprite of a sheet.
+type sheetCell struct {
+	name     string
+	m        *image.NRGBA
+	duration time.Duration
+}
+
+// sheetCells returns the frames or the layers of the current frame as
+// sprites named after base.
+func (li *layeredImage) sheetCells(base string, layers bool) []sheetCell {
+	if !layers {
+		cells := make([]sheetCell, li.frameCount())
+		for i, f := range li.frames {
+			cells[i] = sheetCell{fmt.Sprintf("%s %d", base, i), li.flattenFrame(i), f.duration}
+		}
+		return cells
+	}
+	b := li.bounds
+	cells := make([]sheetCell, len(li.layers))
+	for i, l := range li.layers {
+		m := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(m, m.Rect, layerImage{l, b}, b.Min, draw.Src)
+		cells[i] = sheetCell{fmt.Sprintf("%s %s", base, l.name), m, li.duration()}
+	}
+	return cells
+}
+
+// packSheet places the cells of equal size next to each other without
+// padding and returns the sheet with the position of every cell.
+func packSheet(cells []sheetCell, opts sheetOptions) (*image.NRGBA, []image.Rectangle) {
+	size := cells[0].m.Rect.Size()
+	columns := len(cells)
+	if !opts.strip {
+		columns = opts.columns
+		if columns == 0 {
+			columns = int(math.Ceil(math.Sqrt(float64(len(cells)))))
+		}
+		columns = min(columns, len(cells))
+	}
+	rows := (len(cells) + columns - 1) / columns
+	sheet := image.NewNRGBA(image.Rect(0, 0, columns*size.X, rows*size.Y))
+	rects := make([]image.Rectangle, len(cells))
+	for i, cell := range cells {
+		min := image.Pt(i%columns*size.X, i/columns*size.Y)
+		rects[i] = image.Rectangle{min, min.Add(size)}
+		draw.Draw(sheet, rects[i], cell.m, image.Point{}, draw.Src)
+	}
+	return sheet, rects
+}
+
+// The atlas uses the JSON hash format of TexturePacker and Aseprite.
+type atlasJSON struct {
+	Frames atlasFrames `json:"frames"`
+	Meta   atlasMeta   `json:"meta"`
+}
+
+type atlasRect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type atlasSize struct {
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type atlasFrame struct {
+	name             string
+	Frame            atlasRect `json:"frame"`
+	Rotated          bool      `json:"rotated"`
+	Trimmed          bool      `json:"trimmed"`
+	SpriteSourceSize atlasRect `json:"spriteSourceSize"`
+	SourceSize       atlasSize `json:"sourceSize"`
+	// Duration is in milliseconds
+	Duration int64 `json:"duration"`
+}
+
+type atlasMeta struct {
+	App     string    `json:"app"`
+	Version string    `json:"version"`
+	Image   string    `json:"image"`
+	Format  string    `json:"format"`
+	Size    atlasSize `json:"size"`
+	Scale   string    `json:"scale"`
+}
+
+// atlasFrames is written as a JSON object keeping the order of the frames.
+type atlasFrames []atlasFrame
+
+func (af atlasFrames) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range af {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(f.name)
+		if err != nil {
+			return nil, err
+		}
+		frame, err := json.Marshal(f)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(frame)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// newAtlas describes the cells packed into the sheet image.
+func newAtlas(imageName string, sheet *image.NRGBA, cells []sheetCell, rects []image.Rectangle) atlasJSON {
+	frames := make(atlasFrames, len(cells))
+	for i, cell := range cells {
+		r := rects[i]
+		frames[i] = atlasFrame{
+			name:             cell.name,
+			Frame:            atlasRect{r.Min.X, r.Min.Y, r.Dx(), r.Dy()},
+			SpriteSourceSize: atlasRect{0, 0, r.Dx(), r.Dy()},
+			SourceSize:       atlasSize{r.Dx(), r.Dy()},
+			Duration:         cell.duration.Milliseconds(),
+		}
+	}
+	return atlasJSON{
+		Frames: frames,
+		Meta: atlasMeta{
+			App:     "https://github.com/aquilax/cmdpxl-go",
+			Version: "1.0",
+			Image:   imageName,
+			Format:  "RGBA8888",
+			Size:    atlasSize{sheet.Rect.Dx(), sheet.Rect.Dy()},
+			Scale:   "1",
+		},
+	}
+}
+
+// writeSheet saves the sprite sheet as PNG and the atlas next to it with the
+// .json extension.
+func writeSheet(fileName string, m *layeredImage, opts sheetOptions, saveImage saveImageCallback) error {
+	if fileName == stdio {
+		return fmt.Errorf("sprite sheets need a file name for the atlas")
+	}
+	base := strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+	cells := m.sheetCells(base, opts.layers)
+	sheet, rects := packSheet(cells, opts)
+	if err := saveImage(fileName, sheet); err != nil {
+		return err
+	}
+	atlas, err := json.MarshalIndent(newAtlas(filepath.Base(fileName), sheet, cells, rects), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(strings.TrimSuffix(fileName, filepath.Ext(fileName))+".json", append(atlas, '\n'), 0644)
+}
+
+// sliceImage splits the image into frames of width x height pixels, read row
+// by row. Every frame gets the layers of the image with the pixels of its
+// cell. Empty cells at the end of the sheet are dropped. Only images with a
+// single frame can be sliced.
+func sliceImage(m *layeredImage, width, height int) (command, error) {
+	if m.frameCount() > 1 {
+		return nil, fmt.Errorf("only images with a single frame can be sliced, the image has %d frames", m.frameCount())
+	}
+	b := m.bounds
+	columns, rows := b.Dx()/max(1, width), b.Dy()/max(1, height)
+	if width < 1 || height < 1 || columns*rows == 0 {
+		return nil, fmt.Errorf("the image is smaller than a %dx%d cell", width, height)
+	}
+	return changeLayers(m, func(m *layeredImage) bool {
+		frames := make([]*frame, columns*rows)
+		last := 0
+		for i := range frames {
+			min := b.Min.Add(image.Pt(i%columns*width, i/columns*height))
+			layers := make([]*imageLayer, len(m.layers))
+			for j, l := range m.layers {
+				layers[j] = &imageLayer{l.name, l.visible, l.opacity, l.blend, image.NewNRGBA(image.Rect(0, 0, width, height))}
+				draw.Draw(layers[j].pixels, layers[j].pixels.Rect, l.pixels, min, draw.Src)
+				if !layers[j].isEmpty() {
+					last = i
+				}
+			}
+			frames[i] = &frame{layers, m.current, defaultFrameDuration}
+		}
+		frames = frames[:last+1]
+		m.frames, m.frame = frames, 0
+		m.layers, m.current = frames[0].layers, frames[0].current
+		frames[0].layers = nil
+		m.bounds = image.Rect(0, 0, width, height)
+		return true
+	}), nil
+}
+
+// parseCellSize parses sizes in the WIDTHxHEIGHT format.
+func parseCellSize(size string) (int, int, error) {
+	parts := strings.Split(size, "x")
+	if len(parts) == 2 {
+		w, errW := strconv.Atoi(parts[0])
+		h, errH := strconv.Atoi(parts[1])
+		if errW == nil && errH == nil && w > 0 && h > 0 {
+			return w, h, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid cell size %s, use WIDTHxHEIGHT", size)
+}
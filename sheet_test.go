@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_packSheet(t *testing.T) {
+	cells := make([]sheetCell, 5)
+	for i := range cells {
+		cells[i] = sheetCell{m: image.NewNRGBA(image.Rect(0, 0, 2, 3))}
+	}
+	tests := []struct {
+		name     string
+		opts     sheetOptions
+		wantSize image.Point
+		wantLast image.Point
+	}{
+		{"square grid", sheetOptions{}, image.Pt(6, 6), image.Pt(2, 3)},
+		{"columns", sheetOptions{columns: 2}, image.Pt(4, 9), image.Pt(0, 6)},
+		{"strip", sheetOptions{strip: true, columns: 2}, image.Pt(10, 3), image.Pt(8, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sheet, rects := packSheet(cells, tt.opts)
+			if sheet.Rect.Size() != tt.wantSize {
+				t.Errorf("packSheet() size = %v, want %v", sheet.Rect.Size(), tt.wantSize)
+			}
+			if rects[4].Min != tt.wantLast {
+				t.Errorf("packSheet() last cell = %v, want %v", rects[4].Min, tt.wantLast)
+			}
+		})
+	}
+}
+
+func Test_writeSheet(t *testing.T) {
+	i, _ := createImage("2,2")
+	m := newLayeredImage(i)
+	red := color.NRGBA{255, 0, 0, 255}
+	m.Set(image.Pt(0, 0), red)
+	m.addFrame(false)
+	m.Set(image.Pt(1, 1), red)
+	m.setDuration(250 * time.Millisecond)
+
+	fileName := filepath.Join(t.TempDir(), "walk.png")
+	if err := writeSheet(fileName, m, sheetOptions{strip: true}, saveImage); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(strings.TrimSuffix(fileName, ".png") + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Index(string(data), `"walk 0"`) > strings.Index(string(data), `"walk 1"`) {
+		t.Errorf("Expected the frames in order, got %s", data)
+	}
+	var atlas struct {
+		Frames map[string]struct {
+			Frame      atlasRect `json:"frame"`
+			SourceSize atlasSize `json:"sourceSize"`
+			Duration   int       `json:"duration"`
+		} `json:"frames"`
+		Meta atlasMeta `json:"meta"`
+	}
+	if err := json.Unmarshal(data, &atlas); err != nil {
+		t.Fatal(err)
+	}
+	f := atlas.Frames["walk 1"]
+	if f.Frame != (atlasRect{2, 0, 2, 2}) || f.SourceSize != (atlasSize{2, 2}) || f.Duration != 250 {
+		t.Errorf("Unexpected second frame %+v", f)
+	}
+	if atlas.Meta.Image != "walk.png" || atlas.Meta.Size != (atlasSize{4, 2}) {
+		t.Errorf("Unexpected meta %+v", atlas.Meta)
+	}
+
+	sheet, err := loadImage(fileName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sliced := newLayeredImage(sheet)
+	cmd, err := sliceImage(sliced, 2, 2)
+	if err != nil || sliced.frameCount() != 2 || sliced.Bounds() != image.Rect(0, 0, 2, 2) {
+		t.Fatalf("Expected 2 frames of 2x2, got %d of %v", sliced.frameCount(), sliced.Bounds())
+	}
+	if !sameColor(sliced.frameAt(1, image.Pt(1, 1)), red) || !sameColor(sliced.frameAt(1, image.Pt(0, 0)), color.Transparent) {
+		t.Errorf("Expected the second frame to be sliced from the sheet, got %v", framePixels(sliced, image.Pt(1, 1)))
+	}
+	cmd.undo(sliced)
+	if sliced.frameCount() != 1 || sliced.Bounds() != image.Rect(0, 0, 4, 2) {
+		t.Errorf("Expected undo to restore the sheet, got %d frames of %v", sliced.frameCount(), sliced.Bounds())
+	}
+}
+
+func Test_sliceImage_dropsEmptyCells(t *testing.T) {
+	i, _ := createImage("2,6")
+	m := newLayeredImage(i)
+	m.Set(image.Pt(2, 0), color.White)
+	sliceImage(m, 2, 2)
+	if m.frameCount() != 2 {
+		t.Errorf("Expected the empty cell at the end to be dropped, got %d frames", m.frameCount())
+	}
+}
+
+func Test_sliceImage_keepsLayers(t *testing.T) {
+	i, _ := createImage("2,4")
+	m := newLayeredImage(i)
+	m.Set(image.Pt(3, 1), color.White)
+	m.addLayer()
+	m.activeLayer().name = "Outline"
+	red := color.NRGBA{255, 0, 0, 255}
+	m.Set(image.Pt(3, 1), red)
+	if _, err := sliceImage(m, 2, 2); err != nil {
+		t.Fatal(err)
+	}
+	m.selectFrame(1)
+	if len(m.layers) != 2 || m.layers[1].name != "Outline" || m.current != 1 {
+		t.Fatalf("Expected the layers in every frame, got %d layers", len(m.layers))
+	}
+	if !sameColor(m.layers[1].at(image.Pt(1, 1)), red) || !sameColor(m.layers[0].at(image.Pt(1, 1)), color.White) {
+		t.Errorf("Expected the cell of every layer, got %v", framePixels(m, image.Pt(1, 1)))
+	}
+
+	if _, err := sliceImage(m, 1, 1); err == nil || !strings.Contains(err.Error(), "single frame") {
+		t.Errorf("Expected images with several frames to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris && !zos
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris,!zos
+
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/gdamore/tcell/v2"
+	"golang.org/x/term"
+)
+
+// newTerminalScreen creates the default screen of the platform. Escape
+// sequences are written to stdout when it is the console, as the screen has
+// no terminal device to send them to.
+func newTerminalScreen() (tcell.Screen, io.Writer, error) {
+	s, err := tcell.NewScreen()
+	if err != nil || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return s, nil, err
+	}
+	return s, os.Stdout, nil
+}
@@ -0,0 +1,25 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris || zos
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris zos
+
+package main
+
+import (
+	"io"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// newTerminalScreen creates a screen on the controlling terminal, so stdin
+// and stdout stay free for piping images. The returned writer sends escape
+// sequences directly to the terminal.
+func newTerminalScreen() (tcell.Screen, io.Writer, error) {
+	tty, err := tcell.NewDevTty()
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := tcell.NewTerminfoScreenFromTty(tty)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, tty, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// transforms are the canvas operations of the transform menu.
+var transforms = []struct {
+	name string
+	fn   func(c *CmdPxl) command
+}{
+	{"flip horizontal", func(c *CmdPxl) command { return flipImage(c.m, true) }},
+	{"flip vertical", func(c *CmdPxl) command { return flipImage(c.m, false) }},
+	{"rotate clockwise", func(c *CmdPxl) command { return rotateImage(c.m, true) }},
+	{"rotate counterclockwise", func(c *CmdPxl) command { return rotateImage(c.m, false) }},
+	{"crop to selection", func(c *CmdPxl) command {
+		if c.selection.Empty() {
+			c.message = "nothing selected"
+			return nil
+		}
+		return cropImage(c.m, c.selection)
+	}},
+	{"trim transparent borders", func(c *CmdPxl) command { return trimImage(c.m) }},
+	{"resize canvas", nil},
+}
+
+// canvasSize is the state of the resize dialog.
+type canvasSize struct {
+	width, height int
+	anchor        anchor
+}
+
+func (c *CmdPxl) openTransforms() {
+	names := make([]string, len(transforms))
+	for i, t := range transforms {
+		names[i] = t.name
+	}
+	c.transformMenu = newMenu("Transform", names)
+	c.currentState = stateTransform
+}
+
+func (c *CmdPxl) closeTransforms() {
+	c.transformMenu = nil
+	c.resize = nil
+	c.currentState = stateDrawing
+	c.s.Clear()
+}
+
+func (c *CmdPxl) handleTransformKey(ev *tcell.EventKey) {
+	if c.resize != nil {
+		c.handleResizeKey(ev)
+		return
+	}
+	switch {
+	case ev.Key() == tcell.KeyEscape || ev.Rune() == 'T' || ev.Rune() == 'x':
+		c.closeTransforms()
+	case ev.Key() == tcell.KeyEnter || ev.Rune() == 'e' || ev.Rune() == ' ':
+		t := transforms[c.transformMenu.selected]
+		if t.fn == nil {
+			c.resize = &canvasSize{c.imageWidth, c.imageHeight, anchorTopLeft}
+			c.resizeMenu = newMenu("Resize canvas", nil)
+			c.updateResizeMenu()
+			c.s.Clear()
+			return
+		}
+		c.transform(t.fn(c))
+		c.closeTransforms()
+	case ev.Key() == tcell.KeyUp || ev.Rune() == 'w':
+		c.transformMenu.move(dirDecrease)
+	case ev.Key() == tcell.KeyDown || ev.Rune() == 's':
+		c.transformMenu.move(dirIncrease)
+	}
+}
+
+// transform adds the command to the history, the selection does not match
+// the transformed image anymore.
+func (c *CmdPxl) transform(cmd command) {
+	if cmd != nil {
+		c.do(cmd)
+		c.selectNone()
+	}
+}
+
+// resizeStep is the change of the size by the A/D keys, the anchor moves by a
+// row of the 3x3 grid instead.
+const resizeStep = 10
+
+func (c *CmdPxl) handleResizeKey(ev *tcell.EventKey) {
+	change := func(d int) {
+		switch c.resizeMenu.selected {
+		case 0:
+			c.resize.width = max(1, c.resize.width+d)
+		case 1:
+			c.resize.height = max(1, c.resize.height+d)
+		case 2:
+			if d == resizeStep || d == -resizeStep {
+				d = d / resizeStep * 3
+			}
+			c.resize.anchor = anchor(wrap(int(c.resize.anchor)+d, int(anchorCount)))
+		}
+		c.updateResizeMenu()
+	}
+	switch {
+	case ev.Key() == tcell.KeyEscape || ev.Rune() == 'x':
+		c.closeTransforms()
+	case ev.Key() == tcell.KeyEnter || ev.Rune() == 'e' || ev.Rune() == ' ':
+		c.transform(resizeCanvas(c.m, c.resize.width, c.resize.height, c.resize.anchor))
+		c.closeTransforms()
+	case ev.Key() == tcell.KeyUp || ev.Rune() == 'w':
+		c.resizeMenu.move(dirDecrease)
+	case ev.Key() == tcell.KeyDown || ev.Rune() == 's':
+		c.resizeMenu.move(dirIncrease)
+	case ev.Key() == tcell.KeyLeft || ev.Rune() == 'a':
+		change(-1)
+	case ev.Key() == tcell.KeyRight || ev.Rune() == 'd':
+		change(1)
+	case ev.Rune() == 'A':
+		change(-resizeStep)
+	case ev.Rune() == 'D':
+		change(resizeStep)
+	}
+}
+
+func (c *CmdPxl) updateResizeMenu() {
+	c.resizeMenu.items = []string{
+		fmt.Sprintf("width: %d", c.resize.width),
+		fmt.Sprintf("height: %d", c.resize.height),
+		fmt.Sprintf("anchor: %s", c.resize.anchor),
+	}
+}
+
+func (c *CmdPxl) drawTransformMenu() *drawBox {
+	if c.resize != nil {
+		return c.resizeMenu.draw(c.s, 0, 0, c.interfaceStyle,
+			"[a/d] change [A/D] by 10 or a row",
+			"[e] apply [esc] cancel",
+		)
+	}
+	return c.transformMenu.draw(c.s, 0, 0, c.interfaceStyle,
+		"[e] apply [esc] cancel",
+	)
+}
+
+// syncBounds updates the layout after the size of the image was changed.
+func (c *CmdPxl) syncBounds() {
+	b := c.m.Bounds()
+	if b.Dx() == c.imageWidth && b.Dy() == c.imageHeight {
+		return
+	}
+	c.imageWidth, c.imageHeight = b.Dx(), b.Dy()
+	c.cursor = image.Pt(min(max(c.cursor.X, b.Min.X), b.Max.X-1), min(max(c.cursor.Y, b.Min.Y), b.Max.Y-1))
+	c.selection = c.selection.Intersect(b)
+	c.layout()
+	c.s.Clear()
+}
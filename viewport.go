@@ -0,0 +1,171 @@
+package main
+
+import "image"
+
+// viewport maps between the coordinate systems of the canvas: screen cells,
+// view coordinates relative to the top left visible pixel and image
+// coordinates.
+type viewport struct {
+	canvas image.Rectangle // screen cells of the canvas, Max is inclusive
+	mode   renderMode
+	pan    image.Point     // image coordinates of the top left visible pixel
+	bounds image.Rectangle // image bounds
+}
+
+// viewport returns the current viewport of the canvas.
+func (c *CmdPxl) viewport() viewport {
+	v := viewport{
+		mode:   c.getRenderMode(),
+		pan:    c.pan,
+		bounds: c.m.Bounds(),
+	}
+	if c.imageBox != nil {
+		v.canvas = c.imageBox.getCanvas()
+	}
+	return v
+}
+
+// size returns the number of image pixels which fit on the canvas.
+func (v viewport) size() image.Point {
+	return image.Pt(v.mode.pixels(v.canvas.Dx()+1, v.canvas.Dy()+1))
+}
+
+// visible returns the part of the image shown on the canvas.
+func (v viewport) visible() image.Rectangle {
+	return image.Rectangle{v.pan, v.pan.Add(v.size())}.Intersect(v.bounds)
+}
+
+func (v viewport) viewToImage(p image.Point) image.Point {
+	return p.Add(v.pan)
+}
+
+func (v viewport) imageToView(p image.Point) image.Point {
+	return p.Sub(v.pan)
+}
+
+// screenToView converts a screen position to view coordinates if it is on
+// the canvas. A cell with two pixels maps to the top one.
+func (v viewport) screenToView(pos image.Point) (image.Point, bool) {
+	if pos.X < v.canvas.Min.X || pos.X > v.canvas.Max.X || pos.Y < v.canvas.Min.Y || pos.Y > v.canvas.Max.Y {
+		return image.Point{}, false
+	}
+	return image.Pt(v.mode.pixels(pos.X-v.canvas.Min.X, pos.Y-v.canvas.Min.Y)), true
+}
+
+// screenToImage converts a screen position to image coordinates if it shows
+// a pixel of the image.
+func (v viewport) screenToImage(pos image.Point) (image.Point, bool) {
+	p, ok := v.screenToView(pos)
+	if !ok {
+		return image.Point{}, false
+	}
+	p = v.viewToImage(p)
+	return p, p.In(v.bounds)
+}
+
+// imageToScreen returns the top left screen cell of the image pixel.
+func (v viewport) imageToScreen(p image.Point) image.Point {
+	p = v.imageToView(p)
+	return image.Pt(v.canvas.Min.X+p.X*v.mode.cols, v.canvas.Min.Y+p.Y*v.mode.halfRows/2)
+}
+
+// cellPixels returns the image coordinates of the pixels shown in the upper
+// and lower half of the canvas cell. They are the same unless the cell is
+// drawn with a half block.
+func (v viewport) cellPixels(col, row int) (image.Point, image.Point) {
+	x := col / v.mode.cols
+	top := image.Pt(x, row*2/v.mode.halfRows)
+	bottom := image.Pt(x, (row*2+1)/v.mode.halfRows)
+	return v.viewToImage(top), v.viewToImage(bottom)
+}
+
+// maxPan returns the largest pan which still fills the canvas.
+func (v viewport) maxPan() image.Point {
+	size := v.size()
+	return image.Pt(
+		max(v.bounds.Min.X, v.bounds.Max.X-size.X),
+		max(v.bounds.Min.Y, v.bounds.Max.Y-size.Y),
+	)
+}
+
+// clamp keeps the pan inside of the image.
+func (v viewport) clamp() viewport {
+	maxPan := v.maxPan()
+	v.pan.X = min(max(v.pan.X, v.bounds.Min.X), maxPan.X)
+	v.pan.Y = min(max(v.pan.Y, v.bounds.Min.Y), maxPan.Y)
+	return v
+}
+
+// scroll moves the view by a single pixel wrapping around at the edges of
+// the image.
+func (v viewport) scroll(dx, dy int) viewport {
+	maxPan := v.maxPan()
+	v.pan = v.pan.Add(image.Pt(dx, dy))
+	if v.pan.X < v.bounds.Min.X {
+		v.pan.X = maxPan.X
+	} else if v.pan.X > maxPan.X {
+		v.pan.X = v.bounds.Min.X
+	}
+	if v.pan.Y < v.bounds.Min.Y {
+		v.pan.Y = maxPan.Y
+	} else if v.pan.Y > maxPan.Y {
+		v.pan.Y = v.bounds.Min.Y
+	}
+	return v
+}
+
+// scrollTo moves the view as little as possible to show the pixel.
+func (v viewport) scrollTo(p image.Point) viewport {
+	size := v.size()
+	if p.X < v.pan.X {
+		v.pan.X = p.X
+	} else if p.X >= v.pan.X+size.X {
+		v.pan.X = p.X - size.X + 1
+	}
+	if p.Y < v.pan.Y {
+		v.pan.Y = p.Y
+	} else if p.Y >= v.pan.Y+size.Y {
+		v.pan.Y = p.Y - size.Y + 1
+	}
+	return v.clamp()
+}
+
+// centerOn moves the view to show the pixel in the middle of the canvas.
+func (v viewport) centerOn(p image.Point) viewport {
+	size := v.size()
+	v.pan = image.Pt(p.X-size.X/2, p.Y-size.Y/2)
+	return v.clamp()
+}
+
+// constrain returns the closest visible pixel to p.
+func (v viewport) constrain(p image.Point) image.Point {
+	r := v.visible()
+	if r.Empty() {
+		return p
+	}
+	return image.Pt(min(max(p.X, r.Min.X), r.Max.X-1), min(max(p.Y, r.Min.Y), r.Max.Y-1))
+}
+
+// moveCursor moves the cursor wrapping around at the edges of the image and
+// scrolls the view to keep it on the canvas.
+func (c *CmdPxl) moveCursor(dx, dy int) {
+	b := c.m.Bounds()
+	c.cursor.X = b.Min.X + mod(c.cursor.X+dx-b.Min.X, b.Dx())
+	c.cursor.Y = b.Min.Y + mod(c.cursor.Y+dy-b.Min.Y, b.Dy())
+	c.pan = c.viewport().scrollTo(c.cursor).pan
+}
+
+// scroll pans the view by a single pixel with wrapping, the cursor is moved
+// along when it would leave the canvas.
+func (c *CmdPxl) scroll(dx, dy int) {
+	c.pan = c.viewport().scroll(dx, dy).pan
+	c.cursor = c.viewport().constrain(c.cursor)
+}
+
+// panBy moves the view by the given number of pixels without wrapping around.
+func (c *CmdPxl) panBy(dx, dy int) {
+	v := c.viewport()
+	v.pan = v.pan.Add(image.Pt(dx, dy))
+	c.pan = v.clamp().pan
+	c.cursor = c.viewport().constrain(c.cursor)
+}
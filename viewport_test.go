@@ -0,0 +1,67 @@
+package main
+
+import (
+	"image"
+	"reflect"
+	"testing"
+)
+
+func Test_viewport_screenToImage(t *testing.T) {
+	v := viewport{
+		canvas: image.Rect(1, 1, 10, 5),
+		mode:   renderMode{2, 2},
+		pan:    image.Pt(2, 1),
+		bounds: image.Rect(0, 0, 10, 10),
+	}
+	tests := []struct {
+		name   string
+		pos    image.Point
+		want   image.Point
+		wantOk bool
+	}{
+		{"top left pixel", image.Pt(1, 1), image.Pt(2, 1), true},
+		{"second cell of a pixel", image.Pt(4, 3), image.Pt(3, 3), true},
+		{"border", image.Pt(0, 1), image.Point{}, false},
+		{"below the canvas", image.Pt(1, 6), image.Point{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := v.screenToImage(tt.pos)
+			if ok != tt.wantOk || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("viewport.screenToImage() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+			if ok {
+				if back, _ := v.screenToImage(v.imageToScreen(got)); back != got {
+					t.Errorf("viewport.imageToScreen() does not map back to %v, got %v", got, back)
+				}
+			}
+		})
+	}
+}
+
+func Test_viewport_scrollTo(t *testing.T) {
+	v := viewport{
+		canvas: image.Rect(0, 0, 4, 2),
+		mode:   renderMode{1, 2},
+		bounds: image.Rect(0, 0, 20, 10),
+	}
+	tests := []struct {
+		name string
+		pan  image.Point
+		p    image.Point
+		want image.Point
+	}{
+		{"visible", image.Pt(2, 2), image.Pt(4, 3), image.Pt(2, 2)},
+		{"right of the view", image.Pt(0, 0), image.Pt(7, 0), image.Pt(3, 0)},
+		{"above the view", image.Pt(0, 5), image.Pt(0, 1), image.Pt(0, 1)},
+		{"clamped at the edge", image.Pt(0, 0), image.Pt(19, 9), image.Pt(15, 7)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v.pan = tt.pan
+			if got := v.scrollTo(tt.p).pan; got != tt.want {
+				t.Errorf("viewport.scrollTo() pan = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}